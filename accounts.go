@@ -0,0 +1,517 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/time/rate"
+)
+
+// Passwords are hashed with Argon2id. The parameters are stored with each account, so they can be raised later without
+// invalidating existing passwords.
+const _passwordTime = 1
+const _passwordMemory = 64 * 1024 // KiB
+const _passwordThreads = 4
+const _passwordSaltSize = 16
+const _passwordHashSize = 32
+const _minPasswordLength = 8
+
+const _minUsernameLength = 3
+const _maxUsernameLength = 24
+
+// Link codes attach another device to a player. They can be used once and expire after _linkCodeLifetime.
+const _linkCodeLength = 8
+const _linkCodeLifetime = 10 * time.Minute
+const _linkCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// Requests that check passwords or link codes are rate limited per remote address, and those that check passwords also per
+// username, to make guessing expensive
+const _addressRequestRate = 2
+const _addressRequestBurst = 20
+const _usernameRequestRate = 0.2
+const _usernameRequestBurst = 10
+
+// Kinds of account requests
+const (
+	AccountStatus   = "status"
+	AccountRegister = "register"
+	AccountLogin    = "login"
+	AccountLinkCode = "link-code"
+	AccountLink     = "link"
+)
+
+var errUsernameTaken = errors.New("username is already taken")
+var errInvalidUsername = fmt.Errorf("usernames must be %d to %d letters, digits, '-' or '_'",
+	_minUsernameLength, _maxUsernameLength)
+var errShortPassword = fmt.Errorf("passwords must be at least %d characters long", _minPasswordLength)
+var errWrongPassword = errors.New("wrong username or password")
+var errInvalidLinkCode = errors.New("invalid or expired link code")
+var errHasAccount = errors.New("player already has an account")
+var errCantMerge = errors.New("only guest players can be merged into another player")
+
+// Account lets a player log in with a username and password, so that they can get their player back on any device.
+type Account struct {
+	Username string
+	PlayerID string
+	Salt     []byte
+	Hash     []byte
+	// Argon2id parameters the hash was computed with
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	Created time.Time
+}
+
+// linkCode is a pending one-time code for the player with the given ID
+type linkCode struct {
+	playerID string
+	expires  time.Time
+}
+
+// keyedLimiter rate limits requests separately for each key, e.g. per remote address
+type keyedLimiter struct {
+	mu       sync.Mutex
+	limit    rate.Limit
+	burst    int
+	limiters map[string]*keyedLimiterEntry
+}
+
+type keyedLimiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newKeyedLimiter(limit rate.Limit, burst int) *keyedLimiter {
+	return &keyedLimiter{
+		limit:    limit,
+		burst:    burst,
+		limiters: make(map[string]*keyedLimiterEntry),
+	}
+}
+
+// allow returns true if a request for key may happen now. Limiters that have been idle long enough to be full again are
+// forgotten, since a fresh one behaves the same.
+func (l *keyedLimiter) allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	idle := time.Duration(float64(l.burst) / float64(l.limit) * float64(time.Second))
+	for k, e := range l.limiters {
+		if now.Sub(e.lastSeen) > idle {
+			delete(l.limiters, k)
+		}
+	}
+
+	e, ok := l.limiters[key]
+	if !ok {
+		e = &keyedLimiterEntry{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.limiters[key] = e
+	}
+	e.lastSeen = now
+	return e.limiter.AllowN(now, 1)
+}
+
+// remoteHost returns the address of the client that sent r, without the port
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// newAccount returns an account for the player with the given ID, with the password hashed using a fresh salt
+func newAccount(username, password, playerID string) (*Account, error) {
+	salt := make([]byte, _passwordSaltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Account{
+		Username: username,
+		PlayerID: playerID,
+		Salt:     salt,
+		Hash:     argon2.IDKey([]byte(password), salt, _passwordTime, _passwordMemory, _passwordThreads, _passwordHashSize),
+		Time:     _passwordTime,
+		Memory:   _passwordMemory,
+		Threads:  _passwordThreads,
+		Created:  time.Now(),
+	}, nil
+}
+
+// checkPassword returns true if password is the password of a
+func (a *Account) checkPassword(password string) bool {
+	hash := argon2.IDKey([]byte(password), a.Salt, a.Time, a.Memory, a.Threads, uint32(len(a.Hash)))
+	return subtle.ConstantTimeCompare(hash, a.Hash) == 1
+}
+
+// validUsername returns true if name is an acceptable username
+func validUsername(name string) bool {
+	if len(name) < _minUsernameLength || len(name) > _maxUsernameLength {
+		return false
+	}
+	for _, r := range name {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// accountKey returns the key of the account with the given username. Usernames are case insensitive.
+func accountKey(username string) string {
+	return strings.ToLower(username)
+}
+
+// accountName returns the username of the account of p, or an empty string if p is a guest
+func (p *Player) accountName() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.Account
+}
+
+// Register creates an account with the given username and password for p
+func (s *Server) Register(p *Player, username, password string) error {
+	if !validUsername(username) {
+		return errInvalidUsername
+	}
+	if len(password) < _minPasswordLength {
+		return errShortPassword
+	}
+	if p.isBot() {
+		return errCantMerge
+	}
+
+	// Hashing takes a while, so it is done before taking the lock
+	account, err := newAccount(username, password, p.Id)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Accounts == nil {
+		s.Accounts = make(map[string]*Account)
+	}
+	if _, ok := s.Accounts[accountKey(username)]; ok {
+		return errUsernameTaken
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Account != "" {
+		return errHasAccount
+	}
+	p.Account = username
+	s.Accounts[accountKey(username)] = account
+
+	log.Println("registered account", username, "for player", p.Id)
+	return nil
+}
+
+// Login returns the player of the account with the given username if password is correct
+func (s *Server) Login(username, password string) (*Player, error) {
+	s.mu.RLock()
+	account := s.Accounts[accountKey(username)]
+	s.mu.RUnlock()
+
+	if account == nil {
+		// Hash anyway, so that the response time doesn't tell whether the account exists
+		argon2.IDKey([]byte(password), make([]byte, _passwordSaltSize), _passwordTime, _passwordMemory, _passwordThreads,
+			_passwordHashSize)
+		return nil, errWrongPassword
+	}
+	if !account.checkPassword(password) {
+		return nil, errWrongPassword
+	}
+
+	return s.AddPlayer(account.PlayerID), nil
+}
+
+// NewLinkCode returns a one-time code that attaches another device to p
+func (s *Server) NewLinkCode(p *Player) (string, time.Time, error) {
+	buf := make([]byte, _linkCodeLength)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	for i, b := range buf {
+		buf[i] = _linkCodeAlphabet[int(b)%len(_linkCodeAlphabet)]
+	}
+	code := string(buf)
+	expires := time.Now().Add(_linkCodeLifetime)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for c, lc := range s.linkCodes {
+		if now.After(lc.expires) || lc.playerID == p.Id {
+			delete(s.linkCodes, c)
+		}
+	}
+	s.linkCodes[code] = linkCode{playerID: p.Id, expires: expires}
+
+	return code, expires, nil
+}
+
+// UseLinkCode returns the player code belongs to. The code can't be used again.
+func (s *Server) UseLinkCode(code string) (*Player, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+
+	s.mu.Lock()
+	lc, ok := s.linkCodes[code]
+	delete(s.linkCodes, code)
+	s.mu.Unlock()
+
+	if !ok || time.Now().After(lc.expires) {
+		return nil, errInvalidLinkCode
+	}
+	return s.AddPlayer(lc.playerID), nil
+}
+
+// MergePlayer moves the score, statistics, achievements and uncovered fields of the guest player from into the player to, and
+// removes from. Session tokens of from aren't accepted anymore. Connections that are still open for from keep playing as a player
+// that no longer exists, so the client should reconnect as to.
+func (s *Server) MergePlayer(from, to *Player) error {
+	if from == to {
+		return nil
+	}
+	if from.isBot() || to.isBot() || from.accountName() != "" {
+		return errCantMerge
+	}
+
+	s.mu.Lock()
+	if s.Players[from.Id] != from {
+		// Another request merged the player already
+		s.mu.Unlock()
+		return errCantMerge
+	}
+	delete(s.Players, from.Id)
+	if s.Merged == nil {
+		s.Merged = make(map[string]time.Time)
+	}
+	now := time.Now()
+	for id, merged := range s.Merged {
+		// All tokens of the player have expired by now
+		if now.Sub(merged) > _sessionLifetime {
+			delete(s.Merged, id)
+		}
+	}
+	s.Merged[from.Id] = now
+	for _, ids := range s.pendingFlags {
+		if ids[from.Id] {
+			delete(ids, from.Id)
+			ids[to.Id] = true
+		}
+	}
+	boards := make(map[string]*Leaderboard, len(s.Leaderboards))
+	for name, l := range s.Leaderboards {
+		boards[name] = l
+	}
+	regions := make([]*Leaderboard, 0, len(s.Regions))
+	for _, l := range s.Regions {
+		regions = append(regions, l)
+	}
+
+	// Taken away from the player while it is still locked in, so that nothing is moved twice
+	from.mu.Lock()
+	score := uint(atomic.SwapUint64(&from.Score, 0))
+	bestStreak := uint(atomic.SwapUint64(&from.BestStreak, 0))
+	stats := from.Stats
+	achievements := from.Achievements
+	from.Stats = PlayerStats{}
+	from.Achievements = nil
+	from.mu.Unlock()
+	s.mu.Unlock()
+
+	to.incScore(score)
+	to.raiseBestStreak(bestStreak)
+
+	to.mu.Lock()
+	to.Stats.merge(stats)
+	if to.Achievements == nil {
+		to.Achievements = make(map[string]time.Time)
+	}
+	for id, earned := range achievements {
+		if other, ok := to.Achievements[id]; !ok || earned.Before(other) {
+			to.Achievements[id] = earned
+		}
+	}
+	to.mu.Unlock()
+
+	for name, l := range boards {
+		switch name {
+		case BoardBest, BoardStreak:
+			l.Merge(from.Id, to.Id, maxUint)
+		default:
+			l.Merge(from.Id, to.Id, sumUint)
+		}
+	}
+	s.leaderboard(BoardBest).Raise(to.Id, to.getScore())
+	for _, l := range regions {
+		l.Merge(from.Id, to.Id, sumUint)
+	}
+
+	s.m.ReassignOwner(from.Id, to.Id)
+	log.Println("merged player", from.Id, "into", to.Id)
+
+	s.bus.Publish(PlayersMerged{FromID: from.Id, ToID: to.Id})
+
+	return nil
+}
+
+func sumUint(a, b uint) uint {
+	return a + b
+}
+
+func maxUint(a, b uint) uint {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// AccountRequest is sent to the account handler. Username and Password are used by register and login, Code by link. If Merge is
+// set for login or link, the guest player of the current session is merged into the player that is logged in.
+type AccountRequest struct {
+	Request  string
+	Username string
+	Password string
+	Code     string
+	Merge    bool
+}
+
+// AccountResponse is the answer to an AccountRequest. Username is the username of the account of the player, if they have one.
+// Code and Expires are only set in answers to link-code requests.
+type AccountResponse struct {
+	Username string     `json:",omitempty"`
+	Code     string     `json:",omitempty"`
+	Expires  *time.Time `json:",omitempty"`
+}
+
+// accountHandler handles account requests for the player identified by the session cookie. Players that log in or use a link
+// code get a session cookie for the player of the account or code.
+func (s *Server) accountHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintf(w, "account requests must be POSTed\n")
+		return
+	}
+
+	var req AccountRequest
+	dec := json.NewDecoder(r.Body)
+	err := dec.Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Can't decode request: %s", err)
+		return
+	}
+
+	// Players that haven't been issued a session yet are guests without a player
+	var current *Player
+	id, _, err := s.sessionFromRequest(r)
+	if err == nil && !isBotID(id) {
+		current = s.AddPlayer(id)
+	}
+
+	allowed := true
+	switch req.Request {
+	case AccountRegister, AccountLogin:
+		allowed = s.usernameLimit.allow(accountKey(req.Username)) && s.addressLimit.allow(remoteHost(r))
+	case AccountLink:
+		allowed = s.addressLimit.allow(remoteHost(r))
+	}
+	if !allowed {
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprintf(w, "Too many requests, try again later.\n")
+		return
+	}
+
+	var (
+		res    AccountResponse
+		status = http.StatusBadRequest
+		target *Player
+	)
+	switch req.Request {
+	case AccountStatus:
+	case AccountRegister:
+		if current == nil {
+			err = errNoSession
+			break
+		}
+		err = s.Register(current, req.Username, req.Password)
+		if err == errUsernameTaken || err == errHasAccount {
+			status = http.StatusConflict
+		}
+	case AccountLogin:
+		target, err = s.Login(req.Username, req.Password)
+		status = http.StatusUnauthorized
+	case AccountLinkCode:
+		if current == nil {
+			err = errNoSession
+			break
+		}
+		var expires time.Time
+		res.Code, expires, err = s.NewLinkCode(current)
+		res.Expires = &expires
+		status = http.StatusInternalServerError
+	case AccountLink:
+		target, err = s.UseLinkCode(req.Code)
+		status = http.StatusUnauthorized
+	default:
+		err = fmt.Errorf("unknown request: %s", req.Request)
+	}
+	if err != nil {
+		log.Printf("account request %s failed: %s", req.Request, err)
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "%s\n", err)
+		return
+	}
+
+	if target != nil {
+		if req.Merge && current != nil {
+			err = s.MergePlayer(current, target)
+			if err != nil {
+				w.WriteHeader(http.StatusConflict)
+				fmt.Fprintf(w, "%s\n", err)
+				return
+			}
+		}
+		s.setSessionCookie(w, r, target.Id)
+		current = target
+	}
+	if current != nil {
+		res.Username = current.accountName()
+	}
+
+	if req.Request == AccountRegister {
+		s.bus.Publish(AccountRegistered{PlayerID: current.Id, Username: res.Username})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	err = enc.Encode(res)
+	if err != nil {
+		log.Println("can't encode account response:", err)
+	}
+}
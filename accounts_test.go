@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMergedPlayerToken(t *testing.T) {
+	s := newTestServer(t)
+
+	from := s.AddPlayer(uuid.New().String())
+	to := s.AddPlayer(uuid.New().String())
+	token := s.sessions.Issue(from.Id)
+
+	if _, _, err := s.verifySession(token); err != nil {
+		t.Fatalf("token was rejected before the merge: %s", err)
+	}
+	if err := s.MergePlayer(from, to); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.verifySession(token); err != errMergedPlayer {
+		t.Errorf("got error %v after the merge, want %v", err, errMergedPlayer)
+	}
+}
+
+func TestMergePlayerOnce(t *testing.T) {
+	s := newTestServer(t)
+
+	from := s.AddPlayer(uuid.New().String())
+	to := s.AddPlayer(uuid.New().String())
+	from.incScore(100)
+	to.incScore(10)
+	from.mu.Lock()
+	from.Stats.CellsUncovered = 7
+	from.Stats.FlagsCorrect = 2
+	from.mu.Unlock()
+
+	const merges = 8
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		oks int
+	)
+	for i := 0; i < merges; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := s.MergePlayer(from, to)
+			if err == nil {
+				mu.Lock()
+				oks++
+				mu.Unlock()
+			} else if err != errCantMerge {
+				t.Errorf("got error %v, want %v", err, errCantMerge)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if oks != 1 {
+		t.Errorf("%d of %d merges succeeded, want 1", oks, merges)
+	}
+	if score := to.getScore(); score != 110 {
+		t.Errorf("got score %d, want 110", score)
+	}
+	to.mu.RLock()
+	stats := to.Stats
+	to.mu.RUnlock()
+	if stats.CellsUncovered != 7 || stats.FlagsCorrect != 2 {
+		t.Errorf("got %d uncovered fields and %d correct flags, want 7 and 2", stats.CellsUncovered, stats.FlagsCorrect)
+	}
+	if s.GetPlayer(from.Id) != nil {
+		t.Error("merged player still exists")
+	}
+}
+
+func TestAccountRateLimit(t *testing.T) {
+	s := newTestServer(t)
+
+	err := s.Register(s.AddPlayer(uuid.New().String()), "alice", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(req AccountRequest, addr string) int {
+		body, _ := json.Marshal(req)
+		r := httptest.NewRequest(http.MethodPost, "/account", bytes.NewReader(body))
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		s.accountHandler(w, r)
+		return w.Code
+	}
+
+	// Guessing the password of one account from many addresses
+	for i := 0; i < _usernameRequestBurst; i++ {
+		addr := fmt.Sprintf("192.0.2.%d:1234", i+1)
+		code := request(AccountRequest{Request: AccountLogin, Username: "alice", Password: "wrong"}, addr)
+		if code != http.StatusUnauthorized {
+			t.Fatalf("guess %d: got status %d, want %d", i, code, http.StatusUnauthorized)
+		}
+	}
+	code := request(AccountRequest{Request: AccountLogin, Username: "Alice", Password: "correct horse"}, "198.51.100.1:1234")
+	if code != http.StatusTooManyRequests {
+		t.Errorf("got status %d after %d guesses, want %d", code, _usernameRequestBurst, http.StatusTooManyRequests)
+	}
+
+	// Guessing link codes from one address
+	for i := 0; i < _addressRequestBurst; i++ {
+		if code := request(AccountRequest{Request: AccountLink, Code: "WRONG"}, "203.0.113.1:1234"); code != http.StatusUnauthorized {
+			t.Fatalf("guess %d: got status %d, want %d", i, code, http.StatusUnauthorized)
+		}
+	}
+	if code := request(AccountRequest{Request: AccountLink, Code: "WRONG"}, "203.0.113.1:4321"); code != http.StatusTooManyRequests {
+		t.Errorf("got status %d after %d guesses, want %d", code, _addressRequestBurst, http.StatusTooManyRequests)
+	}
+	if code := request(AccountRequest{Request: AccountLink, Code: "WRONG"}, "203.0.113.2:1234"); code != http.StatusUnauthorized {
+		t.Errorf("got status %d from another address, want %d", code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/farhaven/sweeper/protocol"
+)
+
+// Achievement is earned by a player once the named metric reaches the threshold. Achievements are defined in achievements.json,
+// see PlayerStats and the Metric* constants for the available metrics.
+type Achievement struct {
+	ID          string
+	Name        string
+	Description string
+	Metric      string
+	Threshold   uint64
+}
+
+type Achievements struct {
+	Achievements []Achievement
+}
+
+// Achievements that are used if there is no achievements.json
+var _defaultAchievements = Achievements{
+	Achievements: []Achievement{
+		{"cells-1000", "Digger", "Uncover 1000 fields", MetricCellsUncovered, 1000},
+		{"flags-10", "Flag Bearer", "Place 10 flags on fields that turn out to be mines", MetricFlagsCorrect, 10},
+		{"survivor-500", "Survivor", "Uncover 500 times without triggering a mine", MetricUncoversSinceBoom, 500},
+		{"explorer-10000", "Explorer", "Visit a field 10000 fields away from the origin", MetricDistance, 10000},
+	},
+}
+
+// NewAchievementsFromFile loads the achievement definitions from path. It returns an error if a definition uses an unknown metric
+// or if an ID is used twice.
+func NewAchievementsFromFile(path string) (Achievements, error) {
+	var achievements Achievements
+
+	fh, err := os.Open(path)
+	if err != nil {
+		return achievements, err
+	}
+	defer fh.Close()
+
+	dec := json.NewDecoder(fh)
+	err = dec.Decode(&achievements)
+	if err != nil {
+		return achievements, err
+	}
+
+	seen := make(map[string]bool)
+	for _, a := range achievements.Achievements {
+		if !isMetric(a.Metric) {
+			return achievements, fmt.Errorf("achievement %q uses unknown metric %q", a.ID, a.Metric)
+		}
+		if seen[a.ID] {
+			return achievements, fmt.Errorf("achievement %q is defined twice", a.ID)
+		}
+		seen[a.ID] = true
+	}
+
+	return achievements, nil
+}
+
+// eventPlayerID returns the ID of the player who caused ev, or an empty string if ev isn't caused by a player
+func eventPlayerID(ev GameEvent) string {
+	switch ev := ev.(type) {
+	case CellsUncovered:
+		return ev.PlayerID
+	case MineTriggered:
+		return ev.PlayerID
+	case MarkChanged:
+		return ev.PlayerID
+	case PlayerRenamed:
+		return ev.PlayerID
+	case PlayerMoved:
+		return ev.PlayerID
+	case ScoreChanged:
+		return ev.PlayerID
+	default:
+		return ""
+	}
+}
+
+// achievementsEvent awards the player who caused ev all achievements they have newly earned
+func (s *Server) achievementsEvent(ev GameEvent) {
+	p := s.GetPlayer(eventPlayerID(ev))
+	if p == nil {
+		return
+	}
+
+	for _, a := range s.achievements.Achievements {
+		value, _ := p.metric(a.Metric)
+		if value < a.Threshold {
+			continue
+		}
+
+		p.mu.Lock()
+		_, earned := p.Achievements[a.ID]
+		now := time.Now()
+		if !earned {
+			if p.Achievements == nil {
+				p.Achievements = make(map[string]time.Time)
+			}
+			p.Achievements[a.ID] = now
+		}
+		p.mu.Unlock()
+
+		if earned {
+			continue
+		}
+
+		log.Println("player", p.Id, "earned achievement", a.ID)
+		p.notify(protocol.Event{
+			Event: protocol.EventAchievement,
+			Achievement: &protocol.Achievement{
+				ID:          a.ID,
+				Name:        a.Name,
+				Description: a.Description,
+				Threshold:   a.Threshold,
+				Progress:    value,
+				Earned:      &now,
+			},
+		})
+	}
+}
+
+// GetAchievements returns all achievements along with the number of players who earned them. If p isn't nil, the progress of p
+// and the time at which p earned the achievements are included.
+func (s *Server) GetAchievements(p *Player) []protocol.Achievement {
+	counts := make(map[string]int)
+
+	s.mu.RLock()
+	for _, player := range s.Players {
+		player.mu.RLock()
+		for id := range player.Achievements {
+			counts[id]++
+		}
+		player.mu.RUnlock()
+	}
+	s.mu.RUnlock()
+
+	res := make([]protocol.Achievement, 0, len(s.achievements.Achievements))
+	for _, a := range s.achievements.Achievements {
+		entry := protocol.Achievement{
+			ID:          a.ID,
+			Name:        a.Name,
+			Description: a.Description,
+			Threshold:   a.Threshold,
+			Players:     counts[a.ID],
+		}
+		if p != nil {
+			entry.Progress, _ = p.metric(a.Metric)
+
+			p.mu.RLock()
+			if earned, ok := p.Achievements[a.ID]; ok {
+				entry.Earned = &earned
+			}
+			p.mu.RUnlock()
+		}
+		res = append(res, entry)
+	}
+
+	return res
+}
+
+// achievementsHandler lists all achievements. Players who send their cookie also get their own progress.
+func (s *Server) achievementsHandler(w http.ResponseWriter, r *http.Request) {
+	p := s.playerFromRequest(r)
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	err := enc.Encode(s.GetAchievements(p))
+	if err != nil {
+		log.Println("can't encode achievements:", err)
+	}
+}
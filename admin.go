@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 )
 
 type Admins struct {
@@ -57,8 +58,14 @@ func isAdminUser(cookie string) bool {
 	return allowed
 }
 
+// AdminRequest is sent to the admin handler. Player, Name and LockHours are used by the requests that handle reported names:
+// dismiss-report removes the reports about Player, force-rename renames Player to Name, or to a generated name if Name is empty,
+// and keeps them from changing it for LockHours.
 type AdminRequest struct {
-	Request string
+	Request   string
+	Player    string
+	Name      string
+	LockHours float64
 }
 
 type PlayerListEntry struct {
@@ -111,9 +118,17 @@ func (s *Server) adminHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println("Admin handler called for", r)
 	defer r.Body.Close()
 
+	adminID, _, err := s.sessionFromRequest(r)
+	if err != nil || !isAdminUser(adminID) {
+		log.Println("denying admin request:", err)
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "Denied.\n")
+		return
+	}
+
 	var req AdminRequest
 	dec := json.NewDecoder(r.Body)
-	err := dec.Decode(&req)
+	err = dec.Decode(&req)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(w, "Can't decode request: %s", err)
@@ -133,10 +148,34 @@ func (s *Server) adminHandler(w http.ResponseWriter, r *http.Request) {
 		enc := json.NewEncoder(w)
 		admins := s.adminGetAdmins()
 		enc.Encode(admins)
+	case "get-reports":
+		enc := json.NewEncoder(w)
+		enc.Encode(s.GetReports())
+	case "dismiss-report":
+		if !s.DismissReports(req.Player) {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "no reports for player %s", req.Player)
+			return
+		}
+		s.bus.Publish(ReportsDismissed{PlayerID: req.Player})
+		enc := json.NewEncoder(w)
+		enc.Encode(s.GetReports())
+	case "force-rename":
+		lock := time.Duration(req.LockHours * float64(time.Hour))
+		err = s.ForceRename(req.Player, req.Name, lock)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "can't rename player: %s", err)
+			return
+		}
+		enc := json.NewEncoder(w)
+		enc.Encode(s.GetReports())
 	default:
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(w, "unknown request: %s", req.Request)
 		log.Println("unknown request:", req.Request)
 		return
 	}
+
+	s.bus.Publish(AdminAction{PlayerID: adminID, Request: req.Request})
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+// Rate limits for requests of human players. Bots have their own limits, configured in bots.json.
+const _playerRequestRate = 10
+const _playerRequestBurst = 20
+
+var errUnknownBotToken = errors.New("unknown bot token")
+
+// Bot is a registered bot that may connect with an API token instead of a cookie
+type Bot struct {
+	Name  string
+	Token string
+	// Requests per second and burst size for this bot
+	Rate  float64
+	Burst int
+}
+
+type Bots struct {
+	Bots []Bot
+}
+
+func NewBotsFromFile(path string) (Bots, error) {
+	var bots Bots
+
+	fh, err := os.Open(path)
+	if err != nil {
+		log.Println("Can't get bots file:", err)
+		return bots, err
+	}
+	defer fh.Close()
+
+	dec := json.NewDecoder(fh)
+	err = dec.Decode(&bots)
+
+	return bots, err
+}
+
+// Lookup returns the bot registered with the given token
+func (b Bots) Lookup(token string) (Bot, bool) {
+	for _, bot := range b.Bots {
+		if bot.Token != "" && bot.Token == token {
+			return bot, true
+		}
+	}
+	return Bot{}, false
+}
+
+const _botIDPrefix = "bot:"
+
+// PlayerID returns the ID under which the bot's player is stored
+func (b Bot) PlayerID() string {
+	return _botIDPrefix + b.Name
+}
+
+// isBotID returns true if id belongs to a bot. Such IDs must not be accepted from cookies.
+func isBotID(id string) bool {
+	return strings.HasPrefix(id, _botIDPrefix)
+}
+
+// Limiter returns a rate limiter for requests made by the bot. Bots without a configured rate get the same limits as players.
+func (b Bot) Limiter() *rate.Limiter {
+	if b.Rate <= 0 || b.Burst <= 0 {
+		return rate.NewLimiter(_playerRequestRate, _playerRequestBurst)
+	}
+	return rate.NewLimiter(rate.Limit(b.Rate), b.Burst)
+}
+
+// botFromAuthorization returns the bot identified by the bearer token in the given Authorization header value. The second return
+// value is false if the header doesn't contain a bearer token, the error is non-nil if it contains an unknown token.
+func botFromAuthorization(header string) (Bot, bool, error) {
+	const prefix = "Bearer "
+
+	if !strings.HasPrefix(header, prefix) {
+		return Bot{}, false, nil
+	}
+
+	bots, err := NewBotsFromFile("bots.json")
+	if err != nil {
+		return Bot{}, true, err
+	}
+
+	bot, ok := bots.Lookup(strings.TrimPrefix(header, prefix))
+	if !ok {
+		return Bot{}, true, errUnknownBotToken
+	}
+
+	return bot, true, nil
+}
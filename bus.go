@@ -0,0 +1,224 @@
+package main
+
+import (
+	"image"
+	"log"
+	"sync"
+
+	"github.com/farhaven/sweeper/protocol"
+)
+
+// GameEvent is something that happened in the game. Subscribers of the bus receive all game events and pick the ones they are
+// interested in with a type switch.
+type GameEvent interface {
+	gameEvent()
+}
+
+// CellsUncovered is published when a player uncovered a field without hitting a mine. Revealed is the number of fields that were
+// uncovered, including those uncovered by a flood fill, and Points the number of points they are worth before any multipliers.
+type CellsUncovered struct {
+	PlayerID string
+	At       image.Point
+	Points   int
+	Revealed int
+}
+
+// MineTriggered is published when a player uncovered a mine
+type MineTriggered struct {
+	PlayerID string
+	At       image.Point
+}
+
+// MarkChanged is published when a player changed the mark on a covered field
+type MarkChanged struct {
+	PlayerID string
+	At       image.Point
+	Mark     Mark
+}
+
+// PlayerRenamed is published when a player changed their name. The names are display names, i.e. never empty.
+type PlayerRenamed struct {
+	PlayerID string
+	OldName  string
+	NewName  string
+}
+
+// PlayerMoved is published when a player moved their viewport by Delta. Viewport is the new viewport.
+type PlayerMoved struct {
+	PlayerID string
+	Delta    image.Point
+	Viewport image.Rectangle
+}
+
+// ScoreChanged is published when the score of a player changed by Delta to Score
+type ScoreChanged struct {
+	PlayerID string
+	Delta    int
+	Score    uint
+	Reason   string
+}
+
+// LeaderChanged is published when a different player took the lead in the highscores
+type LeaderChanged struct {
+	Name  string
+	Score uint
+}
+
+// HintBought is published when a player paid Cost points for a hint
+type HintBought struct {
+	PlayerID string
+	Cost     uint
+}
+
+// NameReported is published when a player reported the name of the player with the ID TargetID to the admins
+type NameReported struct {
+	PlayerID string
+	TargetID string
+	Name     string
+}
+
+// ReportsDismissed is published when an admin dismissed the reports about the name of a player
+type ReportsDismissed struct {
+	PlayerID string
+}
+
+// AccountRegistered is published when a player registered an account
+type AccountRegistered struct {
+	PlayerID string
+	Username string
+}
+
+// PlayersMerged is published when the guest player with the ID FromID was merged into the player with the ID ToID
+type PlayersMerged struct {
+	FromID string
+	ToID   string
+}
+
+// AdminAction is published when an admin sent a request to the admin handler. PlayerID is the ID of the admin.
+type AdminAction struct {
+	PlayerID string
+	Request  string
+}
+
+func (CellsUncovered) gameEvent()    {}
+func (MineTriggered) gameEvent()     {}
+func (MarkChanged) gameEvent()       {}
+func (PlayerRenamed) gameEvent()     {}
+func (PlayerMoved) gameEvent()       {}
+func (ScoreChanged) gameEvent()      {}
+func (LeaderChanged) gameEvent()     {}
+func (HintBought) gameEvent()        {}
+func (NameReported) gameEvent()      {}
+func (ReportsDismissed) gameEvent()  {}
+func (AccountRegistered) gameEvent() {}
+func (PlayersMerged) gameEvent()     {}
+func (AdminAction) gameEvent()       {}
+
+// Bus delivers game events to subscribers. Events are delivered synchronously, in the order in which the subscribers were
+// registered, so a subscriber can rely on the ones registered before it having seen the event. Events must not be published while
+// holding the lock of the mine field or the server.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers []func(GameEvent)
+}
+
+// NewBus returns a bus without subscribers
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe registers fn to be called for every event published on the bus
+func (b *Bus) Subscribe(fn func(GameEvent)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subscribers = append(b.subscribers, fn)
+}
+
+// Publish delivers ev to all subscribers. A nil bus drops all events.
+func (b *Bus) Publish(ev GameEvent) {
+	if b == nil {
+		return
+	}
+
+	b.mu.RLock()
+	subscribers := b.subscribers
+	b.mu.RUnlock()
+
+	for _, fn := range subscribers {
+		fn(ev)
+	}
+}
+
+// subscribeDefaults registers the subscribers that implement the side effects of game events. Gameplay comes first so that the
+// state is complete by the time it is persisted and sent to the players.
+func (s *Server) subscribeDefaults() {
+	s.bus.Subscribe(s.logEvent)
+	s.bus.Subscribe(s.playEvent)
+	s.bus.Subscribe(s.statsEvent)
+	s.bus.Subscribe(s.leaderboardEvent)
+	s.bus.Subscribe(s.achievementsEvent)
+	s.bus.Subscribe(s.persistEvent)
+	s.bus.Subscribe(s.notifyEvent)
+}
+
+// logEvent logs all game events
+func (s *Server) logEvent(ev GameEvent) {
+	log.Printf("game event %T: %+v", ev, ev)
+}
+
+// playEvent applies the consequences of uncovering fields to the player who did it
+func (s *Server) playEvent(ev GameEvent) {
+	switch ev := ev.(type) {
+	case CellsUncovered:
+		p := s.GetPlayer(ev.PlayerID)
+		if p == nil {
+			return
+		}
+		awarded := p.awardPoints(uint(ev.Points))
+		s.addRegionPoints(ev.At, p.Id, awarded)
+		if ev.Revealed >= _bigFloodFill {
+			s.addToFeed(protocol.FeedFloodFill, &ev.At, "%s uncovered %d fields at once", p.displayName(), ev.Revealed)
+		}
+		s.checkLeader()
+	case MineTriggered:
+		p := s.GetPlayer(ev.PlayerID)
+		if p == nil {
+			return
+		}
+		p.resetStreak()
+		p.handleBoom(ev.At)
+	case PlayerRenamed:
+		s.addToFeed(protocol.FeedRename, nil, "%s is now known as %s", ev.OldName, ev.NewName)
+	}
+}
+
+// persistEvent saves the parts of the state that were changed by ev
+func (s *Server) persistEvent(ev GameEvent) {
+	switch ev.(type) {
+	case CellsUncovered, MineTriggered, MarkChanged, PlayersMerged:
+		err := s.m.Persist()
+		if err != nil {
+			log.Println("can't persist minefield:", err)
+		}
+	}
+
+	switch ev.(type) {
+	case CellsUncovered, MineTriggered, MarkChanged, PlayerRenamed, PlayerMoved, HintBought, NameReported, ReportsDismissed,
+		AccountRegistered, PlayersMerged:
+		err := s.Persist()
+		if err != nil {
+			log.Println("can't persist player list:", err)
+		}
+	}
+}
+
+// notifyEvent sends state updates to all players if ev changed something they can see. Moves only concern the moving player,
+// whose connection takes care of the update.
+func (s *Server) notifyEvent(ev GameEvent) {
+	switch ev.(type) {
+	case CellsUncovered, MineTriggered, MarkChanged, PlayerRenamed, PlayersMerged:
+		// TODO: Only trigger updates in overlapping viewports
+		s.TriggerGlobalUpdate()
+	}
+}
@@ -0,0 +1,332 @@
+// Package client implements a connection to a sweeper server that speaks the websocket protocol defined in package protocol.
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/farhaven/sweeper/protocol"
+	"github.com/gorilla/websocket"
+)
+
+// CookieName is the name of the cookie that carries the session token of a player
+const CookieName = "sweeperID"
+
+const _maxReconnectAttempts = 5
+const _eventBuffer = 64
+
+// ErrNoState is returned by methods that need to know the viewport before the first state update has been received
+var ErrNoState = errors.New("no state update received yet")
+
+// ErrClosed is returned by Next after the connection has been closed
+var ErrClosed = errors.New("connection closed")
+
+// ErrNoSession is returned by Dial if the server didn't hand out a session for a new player
+var ErrNoSession = errors.New("server didn't issue a session")
+
+// Conn is a connection to a sweeper server. It transparently reconnects if the connection is lost.
+type Conn struct {
+	url     string
+	session string
+	token   string
+
+	mu     sync.Mutex
+	ws     *websocket.Conn
+	state  *protocol.StateUpdate
+	closed bool
+
+	events chan protocol.Event
+}
+
+// Dial connects to the websocket endpoint at url, identifying as the player the session token belongs to. If session is empty,
+// a session for a new player is requested from the server. It can be retrieved with Session to play as the same player later.
+func Dial(url, session string) (*Conn, error) {
+	if session == "" {
+		var err error
+		session, err = newSession(url)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c := &Conn{
+		url:     url,
+		session: session,
+		events:  make(chan protocol.Event, _eventBuffer),
+	}
+
+	err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// DialBot connects to the websocket endpoint at url as the registered bot identified by token.
+func DialBot(url, token string) (*Conn, error) {
+	c := &Conn{
+		url:    url,
+		token:  token,
+		events: make(chan protocol.Event, _eventBuffer),
+	}
+
+	err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// newSession requests a session for a new player from the server whose websocket endpoint is at wsURL. The server hands out
+// sessions as a cookie when its index page is loaded.
+func newSession(wsURL string) (string, error) {
+	u, err := url.Parse(wsURL)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "wss":
+		u.Scheme = "https"
+	default:
+		u.Scheme = "http"
+	}
+	u.Path = "/"
+
+	resp, err := http.Get(u.String())
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == CookieName {
+			return cookie.Value, nil
+		}
+	}
+	return "", ErrNoSession
+}
+
+func (c *Conn) connect() error {
+	c.mu.Lock()
+	header := http.Header{}
+	if c.token != "" {
+		header.Add("Authorization", "Bearer "+c.token)
+	} else {
+		header.Add("Cookie", (&http.Cookie{Name: CookieName, Value: c.session}).String())
+	}
+	c.mu.Unlock()
+
+	ws, resp, err := websocket.DefaultDialer.Dial(c.url, header)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.ws = ws
+	// The server renews session tokens that are about to expire
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == CookieName {
+			c.session = cookie.Value
+		}
+	}
+	c.mu.Unlock()
+
+	return nil
+}
+
+// reconnect tries to re-establish the connection with an exponential backoff
+func (c *Conn) reconnect() error {
+	var err error
+
+	delay := 100 * time.Millisecond
+	for attempt := 0; attempt < _maxReconnectAttempts; attempt++ {
+		log.Println("reconnecting to", c.url)
+		err = c.connect()
+		if err == nil {
+			return nil
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+
+	return err
+}
+
+// Session returns the session token used by c. It may change when the server renews the token. It is empty for bots.
+func (c *Conn) Session() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.session
+}
+
+// Close closes the connection
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	return c.ws.Close()
+}
+
+// decode decodes msg into update if it is a state update. Events are delivered on the events channel instead. It returns true if
+// msg was a state update.
+func (c *Conn) decode(msg []byte, update *protocol.StateUpdate) (bool, error) {
+	// State updates and events share field names with different types, so only the kind is decoded first
+	var kind struct {
+		Event string
+	}
+	err := json.Unmarshal(msg, &kind)
+	if err != nil {
+		return false, err
+	}
+
+	if kind.Event == "" {
+		return true, json.Unmarshal(msg, update)
+	}
+
+	var ev protocol.Event
+	err = json.Unmarshal(msg, &ev)
+	if err != nil {
+		return false, err
+	}
+
+	select {
+	case c.events <- ev:
+	default:
+		log.Println("dropping event, nobody is listening:", ev.Event)
+	}
+	return false, nil
+}
+
+// Events returns a channel on which events sent by the server are delivered. Events are only received while Next is being called
+// and are dropped if the channel is full.
+func (c *Conn) Events() <-chan protocol.Event {
+	return c.events
+}
+
+// Next blocks until the next state update is received from the server and returns it. If the connection is lost, Next tries to
+// reconnect before giving up.
+func (c *Conn) Next() (protocol.StateUpdate, error) {
+	var update protocol.StateUpdate
+
+	for {
+		c.mu.Lock()
+		ws, closed := c.ws, c.closed
+		c.mu.Unlock()
+
+		if closed {
+			return update, ErrClosed
+		}
+
+		_, msg, err := ws.ReadMessage()
+		if err == nil {
+			isUpdate, err := c.decode(msg, &update)
+			if err != nil {
+				return update, err
+			}
+			if isUpdate {
+				break
+			}
+			continue
+		}
+
+		log.Println("can't read state update:", err)
+		ws.Close()
+		err = c.reconnect()
+		if err != nil {
+			return update, err
+		}
+	}
+
+	c.mu.Lock()
+	if c.state != nil && c.state.LeaderboardsVersion == update.LeaderboardsVersion {
+		// The server only sends leaderboards when they changed, keep the ones that were left out
+		keep := func(board *[]protocol.HighscoreEntry, cached []protocol.HighscoreEntry) {
+			if *board == nil {
+				*board = cached
+			}
+		}
+		keep(&update.Highscores, c.state.Highscores)
+		keep(&update.BestScores, c.state.BestScores)
+		keep(&update.Daily, c.state.Daily)
+		keep(&update.Weekly, c.state.Weekly)
+		keep(&update.BestStreaks, c.state.BestStreaks)
+		keep(&update.Runs, c.state.Runs)
+	}
+	c.state = &update
+	c.mu.Unlock()
+
+	return update, nil
+}
+
+// State returns the most recently received state update
+func (c *Conn) State() (protocol.StateUpdate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == nil {
+		return protocol.StateUpdate{}, ErrNoState
+	}
+	return *c.state, nil
+}
+
+// Send sends req to the server
+func (c *Conn) Send(req protocol.ClientRequest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ws.WriteJSON(req)
+}
+
+// Move shifts the viewport by dx, dy
+func (c *Conn) Move(dx, dy int) error {
+	return c.Send(protocol.Move(dx, dy))
+}
+
+// SetName changes the player's name
+func (c *Conn) SetName(name string) error {
+	return c.Send(protocol.UpdateName(name))
+}
+
+// ReportName reports the name of the player called name to the admins
+func (c *Conn) ReportName(name string) error {
+	return c.Send(protocol.ReportName(name))
+}
+
+// toViewport translates world coordinates into coordinates relative to the last known viewport
+func (c *Conn) toViewport(x, y int) (int, int, error) {
+	state, err := c.State()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	min := state.ViewPort.Position.Min
+	return x - min.X, y - min.Y, nil
+}
+
+// Uncover uncovers the field at the world coordinates x, y. The coordinates are translated using the viewport of the most recent
+// state update, so moves that have not been acknowledged by the server yet are not taken into account.
+func (c *Conn) Uncover(x, y int) error {
+	vx, vy, err := c.toViewport(x, y)
+	if err != nil {
+		return err
+	}
+	return c.Send(protocol.Uncover(vx, vy))
+}
+
+// Mark cycles the mark on the field at the world coordinates x, y. See Uncover for caveats about the coordinate translation.
+func (c *Conn) Mark(x, y int) error {
+	vx, vy, err := c.toViewport(x, y)
+	if err != nil {
+		return err
+	}
+	return c.Send(protocol.Mark(vx, vy))
+}
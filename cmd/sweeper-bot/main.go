@@ -0,0 +1,146 @@
+// sweeper-bot is a reference bot for sweeper. It connects with a bot token and only uncovers fields that can be proven to be
+// safe from the numbers visible in its viewport. If there is nothing left to deduce, it moves on to another area.
+package main
+
+import (
+	"flag"
+	"image"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/farhaven/sweeper/client"
+	"github.com/farhaven/sweeper/protocol"
+	"github.com/farhaven/sweeper/solver"
+)
+
+type bot struct {
+	conn *client.Conn
+
+	// The server rejects actions until then, after the bot triggered a mine
+	cooldownUntil time.Time
+}
+
+// coolDown makes the bot wait for at least d before its next action
+func (b *bot) coolDown(d time.Duration) {
+	until := time.Now().Add(d)
+	if until.After(b.cooldownUntil) {
+		b.cooldownUntil = until
+	}
+}
+
+func isNumber(e protocol.ViewPortElement) bool {
+	return e >= protocol.VPEZero && e <= protocol.VPEEight
+}
+
+// hasNumbers returns true if anything in vp has been uncovered
+func hasNumbers(vp protocol.ViewPort) bool {
+	for _, row := range vp.Data {
+		for _, e := range row {
+			if isNumber(e) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// step performs a single action based on the given state update
+func (b *bot) step(update protocol.StateUpdate) error {
+	vp := update.ViewPort
+
+	// Flags set by other players are not trusted, the solver only uses numbers and triggered mines
+	res := solver.Solve(solver.BoardFromViewPort(vp))
+	if len(res.Safe) != 0 {
+		log.Println("uncovering safe field", res.Safe[0])
+		return b.conn.Uncover(res.Safe[0].X, res.Safe[0].Y)
+	}
+
+	if !hasNumbers(vp) {
+		// Untouched area, we need to open it somewhere. Avoid fields that have already been triggered.
+		size := vp.Position.Size()
+		x, y := rand.Intn(size.X), rand.Intn(size.Y)
+		if vp.Data[y][x] == protocol.VPENone {
+			pt := vp.Position.Min.Add(image.Pt(x, y))
+			log.Println("opening untouched area at", pt)
+			return b.conn.Uncover(pt.X, pt.Y)
+		}
+	}
+
+	dx, dy := rand.Intn(3)-1, rand.Intn(3)-1
+	log.Println("nothing to deduce, moving by", dx*10, dy*10)
+	return b.conn.Move(dx*10, dy*10)
+}
+
+func main() {
+	url := flag.String("url", "ws://localhost:8080/ws", "websocket URL of the sweeper server")
+	token := flag.String("token", "", "bot API token")
+	delay := flag.Duration("delay", 500*time.Millisecond, "delay between actions")
+	flag.Parse()
+
+	conn, err := client.DialBot(*url, *token)
+	if err != nil {
+		log.Fatalln("can't connect to server:", err)
+	}
+	defer conn.Close()
+
+	b := &bot{
+		conn: conn,
+	}
+
+	// Next has to be called continuously for events to be received, and the server doesn't send a state update for actions it
+	// rejected during a cooldown, so updates are read in the background and only the most recent one is kept.
+	updates := make(chan protocol.StateUpdate, 1)
+	go func() {
+		for {
+			update, err := conn.Next()
+			if err != nil {
+				log.Fatalln("can't get state update:", err)
+			}
+			select {
+			case <-updates:
+			default:
+			}
+			updates <- update
+		}
+	}()
+
+	seconds := func(s float64) time.Duration {
+		return time.Duration(s * float64(time.Second))
+	}
+
+	for {
+		var update protocol.StateUpdate
+
+		select {
+		case update = <-updates:
+			b.coolDown(seconds(update.Cooldown))
+		case ev := <-conn.Events():
+			switch {
+			case ev.Event == protocol.EventBoom && ev.Boom.Own:
+				log.Println("triggered a mine at", ev.Boom.X, ev.Boom.Y)
+				b.coolDown(seconds(ev.Boom.Cooldown))
+				continue
+			case ev.Event == protocol.EventCooldown:
+				// The last action was rejected, retry with the most recent state once the cooldown is over
+				log.Println("cooling down for", seconds(ev.Cooldown))
+				b.coolDown(seconds(ev.Cooldown))
+				update, err = conn.State()
+				if err != nil {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+
+		time.Sleep(time.Until(b.cooldownUntil))
+
+		err = b.step(update)
+		if err != nil {
+			log.Fatalln("can't perform action:", err)
+		}
+
+		time.Sleep(*delay)
+	}
+}
@@ -0,0 +1,286 @@
+// sweeper-term is a terminal client for sweeper. It connects to the websocket endpoint of a sweeper server and renders the
+// player's viewport as a colored grid.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/farhaven/sweeper/client"
+	"github.com/farhaven/sweeper/protocol"
+	"golang.org/x/term"
+)
+
+const _numHighscores = 5
+
+type terminal struct {
+	mu     sync.Mutex
+	state  protocol.StateUpdate
+	cursor image.Point
+	status string
+}
+
+// cookedState is the state of the terminal before it was switched to raw mode
+var cookedState *term.State
+
+// setRaw switches the terminal into (or out of) raw mode so that single key presses can be read without waiting for a newline.
+func setRaw(raw bool) error {
+	fd := int(os.Stdin.Fd())
+	if !raw {
+		if cookedState == nil {
+			return nil
+		}
+		err := term.Restore(fd, cookedState)
+		cookedState = nil
+		return err
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	cookedState = state
+	return nil
+}
+
+func colorFor(r protocol.ViewPortElement) string {
+	switch r {
+	case 'P':
+		return "\x1b[1;31m"
+	case 'X':
+		return "\x1b[1;37;41m"
+	case '?':
+		return "\x1b[1;34m"
+	case '0':
+		return "\x1b[90m"
+	case '1':
+		return "\x1b[94m"
+	case '2':
+		return "\x1b[32m"
+	case '3':
+		return "\x1b[91m"
+	case ' ':
+		return "\x1b[100m"
+	default:
+		return "\x1b[35m"
+	}
+}
+
+func (t *terminal) render() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var b strings.Builder
+
+	b.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&b, "%s: %d @ %s\r\n\r\n", t.state.Name, t.state.Score, t.state.ViewPort.Position)
+
+	for y, row := range t.state.ViewPort.Data {
+		for x, elem := range row {
+			if image.Pt(x, y) == t.cursor {
+				b.WriteString("\x1b[7m")
+			}
+			b.WriteString(colorFor(elem))
+			fmt.Fprintf(&b, " %c", elem)
+			b.WriteString("\x1b[0m")
+		}
+		b.WriteString("\r\n")
+	}
+
+	b.WriteString("\r\n")
+	for idx, entry := range t.state.Highscores {
+		if idx >= _numHighscores {
+			break
+		}
+		fmt.Fprintf(&b, "%2d. %-32s %d\r\n", idx+1, entry.Name, entry.Score)
+	}
+
+	b.WriteString("\r\narrows: scroll, hjkl: move cursor, space: uncover, f: mark, n: name, q: quit\r\n")
+	b.WriteString(t.status)
+
+	os.Stdout.WriteString(b.String())
+}
+
+func (t *terminal) moveCursor(dx, dy int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c := t.cursor.Add(image.Pt(dx, dy))
+	size := t.state.ViewPort.Position.Size()
+	if c.X < 0 || c.Y < 0 || c.X >= size.X || c.Y >= size.Y {
+		return
+	}
+	t.cursor = c
+}
+
+func (t *terminal) readUpdates(conn *client.Conn) {
+	for {
+		update, err := conn.Next()
+		if err != nil {
+			t.mu.Lock()
+			t.status = fmt.Sprintf("can't read update: %s\r\n", err)
+			t.mu.Unlock()
+			t.render()
+			return
+		}
+
+		t.mu.Lock()
+		t.state = update
+		t.mu.Unlock()
+		t.render()
+	}
+}
+
+func (t *terminal) readEvents(conn *client.Conn) {
+	for ev := range conn.Events() {
+		var status string
+
+		switch ev.Event {
+		case protocol.EventBoom:
+			if ev.Boom.Own {
+				status = fmt.Sprintf("BOOM! %d lives left, cooling down for %.0f seconds", ev.Boom.Lives, ev.Boom.Cooldown)
+				if ev.Boom.RunEnded {
+					status = "BOOM! Your run is over"
+				}
+			} else {
+				status = fmt.Sprintf("BOOM! %s triggered a mine at %d %d", ev.Boom.Player, ev.Boom.X, ev.Boom.Y)
+			}
+		case protocol.EventScore:
+			status = fmt.Sprintf("%+d points (%s)", ev.Score.Delta, ev.Score.Reason)
+		case protocol.EventCooldown:
+			status = fmt.Sprintf("cooling down for %.0f seconds", ev.Cooldown)
+		case protocol.EventHint:
+			status = "hint: " + ev.Hint.Message
+			if ev.Hint.Deduced {
+				status += fmt.Sprintf(" (%d %d)", ev.Hint.X, ev.Hint.Y)
+			}
+		case protocol.EventError:
+			status = "error: " + ev.Error
+		case protocol.EventFeed:
+			status = "news: " + ev.Feed.Message
+		case protocol.EventAchievement:
+			status = "achievement unlocked: " + ev.Achievement.Name
+		case protocol.EventReported:
+			status = "reported the name " + ev.Reported
+		}
+
+		t.mu.Lock()
+		t.status = status + "\r\n"
+		t.mu.Unlock()
+		t.render()
+	}
+}
+
+// readName asks for a new player name in cooked mode
+func readName(in *bufio.Reader) (string, error) {
+	err := setRaw(false)
+	if err != nil {
+		return "", err
+	}
+	defer setRaw(true)
+
+	fmt.Print("\r\nNew name: ")
+	name, err := in.ReadString('\n')
+	return strings.TrimSpace(name), err
+}
+
+func main() {
+	url := flag.String("url", "ws://localhost:8080/ws", "websocket URL of the sweeper server")
+	session := flag.String("session", "", "session token to play with, a new player is created if empty")
+	flag.Parse()
+
+	conn, err := client.Dial(*url, *session)
+	if err != nil {
+		log.Fatalln("can't connect to server:", err)
+	}
+	defer func() {
+		if conn.Session() != *session {
+			log.Println("your session token is", conn.Session())
+		}
+		conn.Close()
+	}()
+
+	err = setRaw(true)
+	if err != nil {
+		log.Fatalln("can't switch terminal to raw mode:", err)
+	}
+	defer setRaw(false)
+
+	t := &terminal{}
+	go t.readUpdates(conn)
+	go t.readEvents(conn)
+
+	in := bufio.NewReader(os.Stdin)
+	for {
+		key, err := in.ReadByte()
+		if err != nil {
+			return
+		}
+
+		var req *protocol.ClientRequest
+		switch key {
+		case 'q':
+			return
+		case 'h':
+			t.moveCursor(-1, 0)
+		case 'j':
+			t.moveCursor(0, 1)
+		case 'k':
+			t.moveCursor(0, -1)
+		case 'l':
+			t.moveCursor(1, 0)
+		case ' ', 'f':
+			t.mu.Lock()
+			r := protocol.Uncover(t.cursor.X, t.cursor.Y)
+			t.mu.Unlock()
+			if key == 'f' {
+				r.Kind = protocol.KindMark
+			}
+			req = &r
+		case 'n':
+			name, err := readName(in)
+			if err != nil {
+				log.Println("can't read name:", err)
+				continue
+			}
+			r := protocol.UpdateName(name)
+			req = &r
+		case 0x1b:
+			// Arrow keys are sent as ESC [ A-D
+			seq := make([]byte, 2)
+			_, err := io.ReadFull(in, seq)
+			if err != nil || seq[0] != '[' {
+				continue
+			}
+			req = &protocol.ClientRequest{Kind: protocol.KindMove}
+			switch seq[1] {
+			case 'A':
+				req.Y = -1
+			case 'B':
+				req.Y = 1
+			case 'C':
+				req.X = 1
+			case 'D':
+				req.X = -1
+			default:
+				req = nil
+			}
+		}
+
+		if req != nil {
+			err = conn.Send(*req)
+			if err != nil {
+				log.Println("can't send request:", err)
+				return
+			}
+		}
+		t.render()
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+
+	"github.com/farhaven/sweeper/protocol"
+	"github.com/gorilla/websocket"
+)
+
+// Number of events that are buffered per connection before further events are dropped
+const _eventBuffer = 16
+
+// Players whose viewport is at most this far away from an explosion are notified about it
+const _boomBroadcastRadius = 20
+
+func (s *Server) AddEventChannel(ch chan protocol.Event, p *Player) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.eventChannels[ch] = p
+}
+
+func (s *Server) RemoveEventChannel(ch chan protocol.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.eventChannels, ch)
+}
+
+// SendEvent sends ev to all connections of p. Events are dropped for connections that are too slow to receive them.
+func (s *Server) SendEvent(p *Player, ev protocol.Event) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for ch, target := range s.eventChannels {
+		if target != p {
+			continue
+		}
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// BroadcastEvent sends ev to all connected players
+func (s *Server) BroadcastEvent(ev protocol.Event) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for ch := range s.eventChannels {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// BroadcastNear sends ev to all connected players except the given one whose viewport is at most radius fields away from pt.
+func (s *Server) BroadcastNear(pt image.Point, radius int, except *Player, ev protocol.Event) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for ch, p := range s.eventChannels {
+		if p == except {
+			continue
+		}
+
+		p.mu.RLock()
+		near := pt.In(p.Viewport.Inset(-radius))
+		p.mu.RUnlock()
+
+		if !near {
+			continue
+		}
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// notify sends ev to all connections of p
+func (p *Player) notify(ev protocol.Event) {
+	p.s.SendEvent(p, ev)
+}
+
+// notifyScore tells p that its score changed by delta for the given reason
+func (p *Player) notifyScore(delta int, reason string) {
+	if delta == 0 {
+		return
+	}
+
+	score := p.getScore()
+	p.s.bus.Publish(ScoreChanged{PlayerID: p.Id, Delta: delta, Score: score, Reason: reason})
+	p.notify(protocol.Event{
+		Event: protocol.EventScore,
+		Score: &protocol.ScoreChange{
+			Delta:  delta,
+			Score:  score,
+			Reason: reason,
+		},
+	})
+}
+
+// notifyError tells p that something went wrong
+func (p *Player) notifyError(msg string) {
+	p.notify(protocol.Event{
+		Event: protocol.EventError,
+		Error: msg,
+	})
+}
+
+// writeMessage sends msg as a JSON encoded text message on conn
+func writeMessage(conn *websocket.Conn, msg interface{}) error {
+	wr, err := conn.NextWriter(websocket.TextMessage)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(wr)
+	err = enc.Encode(msg)
+	if err != nil {
+		wr.Close()
+		return err
+	}
+
+	return wr.Close()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/farhaven/sweeper/protocol"
+)
+
+// Number of feed entries that are kept
+const _maxFeedEntries = 200
+
+// Flood fills that reveal at least this many fields make it into the feed
+const _bigFloodFill = 100
+
+// Streak records are only announced once they are at least this long
+const _minRecordStreak = 10
+
+// Default and maximum number of feed entries returned per page
+const _defaultFeedPage = 20
+const _maxFeedPage = 100
+
+// addToFeed records a notable event in the feed and sends it to all connected players. loc is the location of the event, or nil
+// if it has none.
+func (s *Server) addToFeed(kind string, loc *image.Point, format string, args ...interface{}) {
+	s.mu.Lock()
+	s.NextFeedID++
+	entry := protocol.FeedEntry{
+		ID:       s.NextFeedID,
+		Time:     time.Now(),
+		Kind:     kind,
+		Message:  fmt.Sprintf(format, args...),
+		Location: loc,
+	}
+	s.Feed = append(s.Feed, entry)
+	if len(s.Feed) > _maxFeedEntries {
+		s.Feed = s.Feed[len(s.Feed)-_maxFeedEntries:]
+	}
+	s.mu.Unlock()
+
+	log.Println("feed:", entry.Message)
+
+	s.BroadcastEvent(protocol.Event{
+		Event: protocol.EventFeed,
+		Feed:  &entry,
+	})
+}
+
+// GetFeed returns up to limit feed entries older than the entry with the ID before, newest first. If before is 0, the newest
+// entries are returned.
+func (s *Server) GetFeed(before uint64, limit int) []protocol.FeedEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := make([]protocol.FeedEntry, 0, limit)
+	for idx := len(s.Feed) - 1; idx >= 0 && len(res) < limit; idx-- {
+		if before != 0 && s.Feed[idx].ID >= before {
+			continue
+		}
+		res = append(res, s.Feed[idx])
+	}
+	return res
+}
+
+// checkLeader adds a feed entry if the leader of the highscores changed
+func (s *Server) checkLeader() {
+	scores := s.GetHighscores()
+	if len(scores) == 0 || scores[0].Score == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	changed := s.Leader != scores[0].Name
+	s.Leader = scores[0].Name
+	s.mu.Unlock()
+
+	if changed {
+		s.bus.Publish(LeaderChanged{Name: scores[0].Name, Score: scores[0].Score})
+		s.addToFeed(protocol.FeedLeader, nil, "%s takes the lead with %d points", scores[0].Name, scores[0].Score)
+	}
+}
+
+// checkStreakRecord adds a feed entry the first time a streak of p beats the best streak of all players. Further increments of
+// the same streak only update the record.
+func (s *Server) checkStreakRecord(p *Player, streak uint) {
+	s.mu.Lock()
+	if streak <= s.RecordStreak {
+		s.mu.Unlock()
+		return
+	}
+	s.RecordStreak = streak
+	s.mu.Unlock()
+
+	p.mu.Lock()
+	announced := p.recordAnnounced
+	p.recordAnnounced = streak >= _minRecordStreak
+	p.mu.Unlock()
+
+	if !announced && streak >= _minRecordStreak {
+		s.addToFeed(protocol.FeedRecord, nil, "%s broke the streak record", p.displayName())
+	}
+}
+
+// feedHandler returns a page of the feed as JSON. The query parameter before selects entries older than the given ID, limit
+// selects the number of entries.
+func (s *Server) feedHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		before uint64
+		limit  = _defaultFeedPage
+		err    error
+	)
+
+	if val := r.URL.Query().Get("before"); val != "" {
+		before, err = strconv.ParseUint(val, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "invalid parameter before: %s", err)
+			return
+		}
+	}
+
+	if val := r.URL.Query().Get("limit"); val != "" {
+		limit, err = strconv.Atoi(val)
+		if err != nil || limit <= 0 || limit > _maxFeedPage {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "limit must be between 1 and %d", _maxFeedPage)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	err = enc.Encode(s.GetFeed(before, limit))
+	if err != nil {
+		log.Println("can't encode feed:", err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// Flags placed by players are counted as correct once the flagged field turns out to be a mine, either because a player triggered
+// it or because the numbers around it prove it. Flagged fields that aren't resolved yet are kept in the pending flags of the
+// server, so that they can be checked again when fields near them are uncovered.
+
+// revealed returns whether the field at pt has been uncovered or triggered, and in the latter case that it is a mine
+func (m *MineField) revealed(pt image.Point) (mine bool, revealed bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, uncovered := m.Uncovered[pt]
+	return m.Triggered[pt], uncovered || m.Triggered[pt]
+}
+
+// resolved returns whether the field at pt is known to be a mine or known to be safe, and which of the two
+func (m *MineField) resolved(pt image.Point) (mine bool, resolved bool) {
+	mine, revealed := m.revealed(pt)
+	if revealed {
+		return mine, true
+	}
+	if m.ProvenMine(pt) {
+		return true, true
+	}
+	return false, false
+}
+
+// flagArea returns the area in which pending flags may have been resolved by uncovering the field at pt
+func (s *Server) flagArea(pt image.Point) image.Rectangle {
+	r := int(math.Ceil(s.m.Rules().FloodFillRadius())) + _flagProofRadius + 1
+	return image.Rect(pt.X-r, pt.Y-r, pt.X+r+1, pt.Y+r+1)
+}
+
+// addPendingFlag remembers that the player with the given ID flagged the field at pt
+func (s *Server) addPendingFlag(pt image.Point, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pendingFlags == nil {
+		s.pendingFlags = make(map[image.Point]map[string]bool)
+	}
+	if s.pendingFlags[pt] == nil {
+		s.pendingFlags[pt] = make(map[string]bool)
+	}
+	s.pendingFlags[pt][id] = true
+}
+
+// resolveFlags checks the pending flags in rect. Flags on fields that turned out to be mines are counted as correct for the
+// players who placed them, flags on fields that turned out to be safe are forgotten.
+func (s *Server) resolveFlags(rect image.Rectangle) {
+	var cells []image.Point
+
+	s.mu.RLock()
+	if len(s.pendingFlags) < rect.Dx()*rect.Dy() {
+		for pt := range s.pendingFlags {
+			if pt.In(rect) {
+				cells = append(cells, pt)
+			}
+		}
+	} else {
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				if pt := image.Pt(x, y); s.pendingFlags[pt] != nil {
+					cells = append(cells, pt)
+				}
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, pt := range cells {
+		mine, resolved := s.m.resolved(pt)
+		if !resolved {
+			continue
+		}
+
+		s.mu.Lock()
+		ids := s.pendingFlags[pt]
+		delete(s.pendingFlags, pt)
+		s.mu.Unlock()
+
+		if !mine {
+			continue
+		}
+		for id := range ids {
+			if p := s.GetPlayer(id); p != nil {
+				p.confirmFlag(pt)
+			}
+		}
+	}
+}
+
+// confirmFlag counts the flag of p on the field at pt as correct, unless it has been counted already
+func (p *Player) confirmFlag(pt image.Point) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Stats.ConfirmedFlags == nil {
+		p.Stats.ConfirmedFlags = make(map[image.Point]bool)
+	}
+	if !p.Stats.ConfirmedFlags[pt] {
+		p.Stats.ConfirmedFlags[pt] = true
+		p.Stats.FlagsCorrect++
+	}
+}
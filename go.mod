@@ -5,5 +5,7 @@ go 1.13
 require (
 	github.com/google/uuid v1.1.1
 	github.com/gorilla/websocket v1.4.1
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1
 	golang.org/x/time v0.0.0-20191024005414-555d28b269f0
 )
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"image"
+	"log"
+
+	"github.com/farhaven/sweeper/protocol"
+	"github.com/farhaven/sweeper/solver"
+)
+
+// WasForcedGuess determines whether uncovering the mine at pt was a forced guess. It looks at the visible state of viewport as it
+// was before the mine at pt was triggered. Only fields on the frontier, i.e. next to an uncovered number, can be forced guesses:
+// clicking into untouched territory is a blind guess the player chose to make. If the player could have proven any covered field
+// in the viewport to be safe, or could have proven pt to be a mine, the explosion was avoidable. Otherwise the player had no
+// choice but to guess.
+func (m *MineField) WasForcedGuess(viewport image.Rectangle, pt image.Point) bool {
+	board := m.VisibleBoard(viewport)
+
+	// Reconstruct the state before the explosion
+	delete(board.Mines, pt)
+
+	onFrontier := false
+	for _, n := range m.Neighbors(pt) {
+		if _, ok := board.Numbers[n]; ok {
+			onFrontier = true
+			break
+		}
+	}
+	if !onFrontier {
+		return false
+	}
+
+	res := solver.Solve(board)
+	if len(res.Safe) != 0 {
+		return false
+	}
+	for _, mine := range res.Mines {
+		if mine == pt {
+			return false
+		}
+	}
+
+	return true
+}
+
+// handleBoom applies the penalty the rules demand for triggering the mine at pt, takes lives away and respawns the player. The
+// player and everyone nearby are notified about the explosion.
+func (p *Player) handleBoom(pt image.Point) {
+	p.mu.RLock()
+	viewport := p.Viewport
+	p.mu.RUnlock()
+
+	rules := p.s.m.Rules()
+	forced := p.s.m.WasForcedGuess(viewport, pt)
+
+	penalty := rules.BoomPenalty(p.getScore(), forced)
+	if !p.decScore(penalty) {
+		// The score changed concurrently and is now lower than the penalty
+		p.resetScore()
+	}
+
+	cooldown := rules.Cooldown(forced)
+	lifeCost := rules.LifeCost(forced)
+	lives, runEnded := p.loseLives(lifeCost, cooldown)
+
+	log.Println("player", p.Id, "triggered mine at", pt, "forced guess:", forced, "lives left:", lives)
+
+	name := p.displayName()
+
+	p.mu.Lock()
+	p.Viewport = rules.Respawn(p.Viewport)
+	p.mu.Unlock()
+
+	p.s.addToFeed(protocol.FeedBoom, &pt, "BOOM! %s triggered a mine", name)
+
+	p.notifyScore(-int(penalty), protocol.ReasonBoom)
+	p.notify(protocol.Event{
+		Event: protocol.EventBoom,
+		Boom: &protocol.Boom{
+			X:        pt.X,
+			Y:        pt.Y,
+			Player:   name,
+			Own:      true,
+			Forced:   forced,
+			Penalty:  penalty,
+			LifeLost: lifeCost != 0,
+			Lives:    lives,
+			Cooldown: cooldown.Seconds(),
+			RunEnded: runEnded,
+		},
+	})
+	p.s.BroadcastNear(pt, _boomBroadcastRadius, p, protocol.Event{
+		Event: protocol.EventBoom,
+		Boom: &protocol.Boom{
+			X:      pt.X,
+			Y:      pt.Y,
+			Player: name,
+		},
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+// testMineField returns a mine field with the visible state described by rows. '#' is a covered field, '*' a triggered mine and
+// a digit an uncovered field with that number. The top left field is at 0, 0.
+func testMineField(rows ...string) *MineField {
+	m := &MineField{
+		Uncovered: make(map[image.Point]int),
+		Triggered: make(map[image.Point]bool),
+		Marks:     make(map[image.Point]Mark),
+		Owners:    make(map[image.Point]string),
+	}
+
+	for y, row := range rows {
+		for x, r := range row {
+			pt := image.Pt(x, y)
+			switch {
+			case r == '*':
+				m.Triggered[pt] = true
+			case r >= '0' && r <= '8':
+				m.Uncovered[pt] = int(r - '0')
+			}
+		}
+	}
+
+	return m
+}
+
+func TestWasForcedGuess(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		rows []string
+		pt   image.Point
+		want bool
+	}{
+		{
+			name: "blind click",
+			rows: []string{
+				"####",
+				"####",
+				"####",
+			},
+			pt: image.Pt(1, 1),
+		},
+		{
+			name: "fifty-fifty",
+			rows: []string{
+				"*##*",
+				"2221",
+				"0000",
+			},
+			pt:   image.Pt(1, 0),
+			want: true,
+		},
+		{
+			name: "safe field elsewhere",
+			rows: []string{
+				"*##*#",
+				"222#1",
+				"00000",
+			},
+			pt: image.Pt(1, 0),
+		},
+		{
+			name: "proven mine",
+			rows: []string{
+				"*#2*",
+				"2221",
+				"0000",
+			},
+			pt: image.Pt(1, 0),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := testMineField(tc.rows...)
+			// The mine at pt has just been triggered
+			m.Triggered[tc.pt] = true
+
+			viewport := image.Rect(0, 0, len(tc.rows[0]), len(tc.rows))
+			if got := m.WasForcedGuess(viewport, tc.pt); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"image"
+	"log"
+	"sync/atomic"
+
+	"github.com/farhaven/sweeper/protocol"
+	"github.com/farhaven/sweeper/solver"
+)
+
+const _defaultHintCost = 50
+
+// decScore subtracts delta from the player's score. It returns false and leaves the score untouched if the score is lower than
+// delta.
+func (p *Player) decScore(delta uint) bool {
+	for {
+		old := atomic.LoadUint64(&p.Score)
+		if old < uint64(delta) {
+			return false
+		}
+		if atomic.CompareAndSwapUint64(&p.Score, old, old-uint64(delta)) {
+			return true
+		}
+	}
+}
+
+// findHint looks for a covered field in viewport that is provably safe. If there is none, it looks for a provable mine that
+// hasn't been flagged yet. The returned coordinates are relative to viewport. The second return value is false if nothing could be
+// deduced.
+func (m *MineField) findHint(viewport image.Rectangle) (protocol.Hint, bool) {
+	board := m.VisibleBoard(viewport)
+	res := solver.Solve(board)
+
+	if len(res.Safe) != 0 {
+		pt := res.Safe[0].Sub(viewport.Min)
+		return protocol.Hint{Deduced: true, X: pt.X, Y: pt.Y, Message: "This field is safe"}, true
+	}
+
+	for _, mine := range res.Mines {
+		if board.Flags[mine] {
+			continue
+		}
+		pt := mine.Sub(viewport.Min)
+		return protocol.Hint{Deduced: true, X: pt.X, Y: pt.Y, Mine: true, Message: "This field contains a mine"}, true
+	}
+
+	return protocol.Hint{}, false
+}
+
+// requestHint looks for a hint in the player's viewport and charges the hint cost if one was found. The hint, or a message
+// explaining why there is none, is sent to the player as an event.
+func (p *Player) requestHint() {
+	p.mu.RLock()
+	viewport := p.Viewport
+	p.mu.RUnlock()
+
+	cost := p.s.hintCost
+
+	hint, ok := p.s.m.findHint(viewport)
+	if !ok {
+		hint = protocol.Hint{Message: "Nothing can be deduced in your viewport, you're on your own"}
+	} else if !p.decScore(cost) {
+		hint = protocol.Hint{Message: "You don't have enough points for a hint"}
+	} else {
+		hint.Cost = cost
+		log.Println("player", p.Id, "paid", cost, "for a hint")
+		p.notifyScore(-int(cost), protocol.ReasonHint)
+		p.s.bus.Publish(HintBought{PlayerID: p.Id, Cost: cost})
+	}
+
+	p.notify(protocol.Event{
+		Event: protocol.EventHint,
+		Hint:  &hint,
+	})
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/farhaven/sweeper/protocol"
+)
+
+// Names of the leaderboards
+const (
+	BoardScore  = "score"  // current score
+	BoardBest   = "best"   // best score ever reached
+	BoardDaily  = "daily"  // points earned today
+	BoardWeekly = "weekly" // points earned this week
+	BoardStreak = "streak" // best streak
+)
+
+// Periods after which leaderboards are cleared
+const (
+	PeriodNone   = ""
+	PeriodDaily  = "daily"
+	PeriodWeekly = "weekly"
+)
+
+// LeaderboardEntry is the score of a player on a leaderboard
+type LeaderboardEntry struct {
+	ID    string
+	Score uint
+}
+
+// Leaderboard ranks players by a score. The ranking is kept sorted as scores change, so reading the top entries is cheap and an
+// update only moves a single entry. Players with a score of zero aren't ranked. The version of the leaderboard changes whenever
+// an update affects the top entries.
+//
+// Leaderboards with a period are cleared when a new period starts. The top entries of the last period are kept.
+type Leaderboard struct {
+	mu sync.RWMutex
+
+	period string
+	start  time.Time
+	scores map[string]uint
+	// IDs of the ranked players, ordered by descending score. Ties are broken by ID.
+	ranking  []string
+	previous []LeaderboardEntry
+	version  uint64
+}
+
+// NewLeaderboard returns an empty leaderboard that is cleared after each period
+func NewLeaderboard(period string) *Leaderboard {
+	return &Leaderboard{
+		period: period,
+		scores: make(map[string]uint),
+	}
+}
+
+// periodStart returns the start of the period that contains t. Days and weeks start at midnight UTC, weeks on Monday.
+func periodStart(period string, t time.Time) time.Time {
+	day := t.UTC().Truncate(24 * time.Hour)
+
+	switch period {
+	case PeriodDaily:
+		return day
+	case PeriodWeekly:
+		sinceMonday := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -sinceMonday)
+	default:
+		return time.Time{}
+	}
+}
+
+// rollover clears the leaderboard if a new period started. Must be called with l.mu held for writing.
+func (l *Leaderboard) rollover(now time.Time) {
+	start := periodStart(l.period, now)
+	if start.Equal(l.start) {
+		return
+	}
+
+	if !l.start.IsZero() {
+		log.Printf("new %s period, clearing leaderboard", l.period)
+		l.previous = l.top(_numHighscores)
+	}
+	l.start = start
+	l.scores = make(map[string]uint)
+	l.ranking = nil
+	l.version++
+}
+
+// position returns the index in the ranking at which a player with the given ID and score is or would be. Must be called with
+// l.mu held.
+func (l *Leaderboard) position(id string, score uint) int {
+	return sort.Search(len(l.ranking), func(i int) bool {
+		other := l.scores[l.ranking[i]]
+		return other < score || (other == score && l.ranking[i] >= id)
+	})
+}
+
+// set changes the score of the player with the given ID. Must be called with l.mu held for writing.
+func (l *Leaderboard) set(id string, score uint) {
+	old, ok := l.scores[id]
+	if ok && old == score {
+		return
+	}
+
+	if ok {
+		idx := l.position(id, old)
+		l.ranking = append(l.ranking[:idx], l.ranking[idx+1:]...)
+		delete(l.scores, id)
+		if idx < _numHighscores {
+			l.version++
+		}
+	}
+
+	if score == 0 {
+		return
+	}
+
+	idx := l.position(id, score)
+	if idx < _numHighscores {
+		l.version++
+	}
+	l.ranking = append(l.ranking, "")
+	copy(l.ranking[idx+1:], l.ranking[idx:])
+	l.ranking[idx] = id
+	l.scores[id] = score
+}
+
+// Set changes the score of the player with the given ID
+func (l *Leaderboard) Set(id string, score uint) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rollover(time.Now())
+	l.set(id, score)
+}
+
+// Raise changes the score of the player with the given ID if score is higher than the current one
+func (l *Leaderboard) Raise(id string, score uint) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rollover(time.Now())
+	if score > l.scores[id] {
+		l.set(id, score)
+	}
+}
+
+// Add adds delta to the score of the player with the given ID
+func (l *Leaderboard) Add(id string, delta uint) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rollover(time.Now())
+	l.set(id, l.scores[id]+delta)
+}
+
+// Merge removes the player with the ID from and changes the score of the player with the ID to to the result of combining both
+// scores
+func (l *Leaderboard) Merge(from, to string, combine func(a, b uint) uint) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rollover(time.Now())
+	score, ok := l.scores[from]
+	if !ok {
+		return
+	}
+	l.set(from, 0)
+	l.set(to, combine(l.scores[to], score))
+}
+
+// top returns the first n entries. Must be called with l.mu held.
+func (l *Leaderboard) top(n int) []LeaderboardEntry {
+	if n > len(l.ranking) {
+		n = len(l.ranking)
+	}
+
+	res := make([]LeaderboardEntry, n)
+	for i, id := range l.ranking[:n] {
+		res[i] = LeaderboardEntry{ID: id, Score: l.scores[id]}
+	}
+	return res
+}
+
+// Top returns the first n entries of the leaderboard
+func (l *Leaderboard) Top(n int) []LeaderboardEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rollover(time.Now())
+	return l.top(n)
+}
+
+// Version returns the current version of the leaderboard
+func (l *Leaderboard) Version() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rollover(time.Now())
+	return l.version
+}
+
+// Previous returns the top entries of the last period
+func (l *Leaderboard) Previous() []LeaderboardEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rollover(time.Now())
+	return append([]LeaderboardEntry(nil), l.previous...)
+}
+
+// leaderboardState is the persisted form of a leaderboard
+type leaderboardState struct {
+	Period   string
+	Start    time.Time
+	Scores   map[string]uint
+	Previous []LeaderboardEntry
+}
+
+// GobEncode implements gob.GobEncoder. The ranking isn't stored, it is rebuilt from the scores when decoding.
+func (l *Leaderboard) GobEncode() ([]byte, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(leaderboardState{
+		Period:   l.period,
+		Start:    l.start,
+		Scores:   l.scores,
+		Previous: l.previous,
+	})
+	return buf.Bytes(), err
+}
+
+// GobDecode implements gob.GobDecoder
+func (l *Leaderboard) GobDecode(data []byte) error {
+	var state leaderboardState
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&state)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.period = state.Period
+	l.start = state.Start
+	l.previous = state.Previous
+	l.scores = make(map[string]uint)
+	l.ranking = nil
+	for id, score := range state.Scores {
+		l.set(id, score)
+	}
+	return nil
+}
+
+// initLeaderboards creates the leaderboards that are missing from the server state. The ones that can be derived from the
+// players are filled in.
+func (s *Server) initLeaderboards() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Leaderboards == nil {
+		s.Leaderboards = make(map[string]*Leaderboard)
+	}
+
+	boards := []struct {
+		name   string
+		period string
+		score  func(p *Player) uint
+	}{
+		{BoardScore, PeriodNone, (*Player).getScore},
+		{BoardBest, PeriodNone, (*Player).getScore},
+		{BoardDaily, PeriodDaily, nil},
+		{BoardWeekly, PeriodWeekly, nil},
+		{BoardStreak, PeriodNone, (*Player).getBestStreak},
+	}
+	for _, b := range boards {
+		if s.Leaderboards[b.name] != nil {
+			continue
+		}
+
+		l := NewLeaderboard(b.period)
+		if b.score != nil {
+			for id, p := range s.Players {
+				l.Set(id, b.score(p))
+			}
+		}
+		s.Leaderboards[b.name] = l
+	}
+}
+
+// leaderboard returns the leaderboard with the given name
+func (s *Server) leaderboard(name string) *Leaderboard {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.Leaderboards[name]
+}
+
+// highscoreEntries resolves the players of entries to their names
+func (s *Server) highscoreEntries(entries []LeaderboardEntry) []protocol.HighscoreEntry {
+	res := make([]protocol.HighscoreEntry, 0, len(entries))
+	for _, e := range entries {
+		entry := protocol.HighscoreEntry{
+			Name:  _anonName,
+			Score: e.Score,
+		}
+		if p := s.GetPlayer(e.ID); p != nil {
+			entry.Name = p.displayName()
+			entry.Bot = p.isBot()
+		}
+		res = append(res, entry)
+	}
+	return res
+}
+
+// GetLeaderboard returns the top entries of the named leaderboard
+func (s *Server) GetLeaderboard(name string) []protocol.HighscoreEntry {
+	return s.highscoreEntries(s.leaderboard(name).Top(_numHighscores))
+}
+
+// leaderboardsVersion returns a number that changes whenever the contents of the global leaderboards or the best runs change,
+// including changes to the names of players. It is never zero.
+func (s *Server) leaderboardsVersion() uint64 {
+	s.mu.RLock()
+	version := s.namesVersion + s.runsVersion + 1
+	boards := make([]*Leaderboard, 0, len(s.Leaderboards))
+	for _, l := range s.Leaderboards {
+		boards = append(boards, l)
+	}
+	s.mu.RUnlock()
+
+	for _, l := range boards {
+		version += l.Version()
+	}
+	return version
+}
+
+// leaderboardEvent keeps the leaderboards up to date as scores change
+func (s *Server) leaderboardEvent(ev GameEvent) {
+	if _, ok := ev.(PlayerRenamed); ok {
+		s.mu.Lock()
+		s.namesVersion++
+		s.mu.Unlock()
+		return
+	}
+
+	sc, ok := ev.(ScoreChanged)
+	if !ok {
+		return
+	}
+
+	s.leaderboard(BoardScore).Set(sc.PlayerID, sc.Score)
+	s.leaderboard(BoardBest).Raise(sc.PlayerID, sc.Score)
+	if sc.Delta > 0 {
+		s.leaderboard(BoardDaily).Add(sc.PlayerID, uint(sc.Delta))
+		s.leaderboard(BoardWeekly).Add(sc.PlayerID, uint(sc.Delta))
+	}
+}
+
+// leaderboardsHandler serves the leaderboard given by the board parameter, along with the winners of its last period
+func (s *Server) leaderboardsHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("board")
+	l := s.leaderboard(name)
+	if l == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "unknown leaderboard: %q", name)
+		return
+	}
+
+	res := struct {
+		Entries  []protocol.HighscoreEntry
+		Previous []protocol.HighscoreEntry `json:",omitempty"`
+	}{
+		Entries:  s.highscoreEntries(l.Top(_numHighscores)),
+		Previous: s.highscoreEntries(l.Previous()),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	err := enc.Encode(res)
+	if err != nil {
+		log.Println("can't encode leaderboard:", err)
+	}
+}
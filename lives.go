@@ -0,0 +1,119 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/farhaven/sweeper/protocol"
+)
+
+// RunEntry is a finished run, i.e. the score a player reached before losing all of their lives
+type RunEntry struct {
+	Name  string
+	Score uint
+	Bot   bool
+	Ended time.Time
+}
+
+// archiveRun records the final score of a run of p in the runs leaderboard. Only the best runs are kept.
+func (s *Server) archiveRun(p *Player, score uint) {
+	name := p.displayName()
+
+	p.mu.RLock()
+	entry := RunEntry{
+		Name:  name,
+		Score: score,
+		Bot:   p.IsBot,
+		Ended: time.Now(),
+	}
+	p.mu.RUnlock()
+
+	s.mu.Lock()
+	record := score > 0 && (len(s.Runs) == 0 || score > s.Runs[0].Score)
+	s.Runs = append(s.Runs, entry)
+	sort.SliceStable(s.Runs, func(i, j int) bool {
+		return s.Runs[i].Score > s.Runs[j].Score
+	})
+	if len(s.Runs) > _numHighscores {
+		s.Runs = s.Runs[:_numHighscores]
+	}
+	s.runsVersion++
+	s.mu.Unlock()
+
+	if record {
+		s.addToFeed(protocol.FeedRecord, nil, "%s finished the best run ever with %d points", entry.Name, score)
+	}
+}
+
+// GetRuns returns the best finished runs
+func (s *Server) GetRuns() []protocol.HighscoreEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := make([]protocol.HighscoreEntry, 0, len(s.Runs))
+	for _, r := range s.Runs {
+		res = append(res, protocol.HighscoreEntry{
+			Name:  r.Name,
+			Score: r.Score,
+			Bot:   r.Bot,
+		})
+	}
+	return res
+}
+
+// cooldownRemaining returns how long the player has to wait before uncovering or marking fields again
+func (p *Player) cooldownRemaining() time.Duration {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	remaining := time.Until(p.CooldownUntil)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// rejectDuringCooldown returns true if the player is cooling down after an explosion, in which case the player is told how long
+// the cooldown lasts. Nothing changed, so no state update is sent for the rejected request.
+func (p *Player) rejectDuringCooldown() bool {
+	remaining := p.cooldownRemaining()
+	if remaining == 0 {
+		return false
+	}
+
+	log.Println("player", p.Id, "is cooling down, ignoring request")
+	p.notify(protocol.Event{
+		Event:    protocol.EventCooldown,
+		Cooldown: remaining.Seconds(),
+	})
+	return true
+}
+
+// loseLives takes lives away from the player and starts a cooldown. If the player has no lives left, the run ends: its score is
+// archived, the score and streak are reset and the player gets a fresh set of lives. It returns the number of lives left and
+// whether the run ended.
+func (p *Player) loseLives(lives uint, cooldown time.Duration) (uint, bool) {
+	rules := p.s.m.Rules()
+
+	p.mu.Lock()
+	p.CooldownUntil = time.Now().Add(cooldown)
+	if lives < p.Lives {
+		p.Lives -= lives
+		left := p.Lives
+		p.mu.Unlock()
+		return left, false
+	}
+	p.Lives = rules.Lives()
+	left := p.Lives
+	p.mu.Unlock()
+
+	score := p.getScore()
+	log.Println("run of player", p.Id, "ended with score", score)
+	p.s.archiveRun(p, score)
+	p.resetScore()
+	p.resetStreak()
+	p.notifyScore(-int(score), protocol.ReasonRunEnded)
+
+	return left, true
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
 	"strings"
 
 	"github.com/google/uuid"
@@ -19,23 +21,22 @@ var websocketUpgrader = websocket.Upgrader{
 	},
 }
 
-func handleIndex(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 	log.Println("got request for path", r.URL.Path)
 
-	cookie, err := r.Cookie("sweeperID")
+	id, rotate, err := s.sessionFromRequest(r)
 	if err != nil {
-		log.Println("Generating ID cookie")
-		id := uuid.New().String()
-		cookie = &http.Cookie{
-			Name:  "sweeperID",
-			Value: id,
-		}
-		http.SetCookie(w, cookie)
+		log.Println("Generating new session:", err)
+		id = uuid.New().String()
+		rotate = true
 	} else {
-		log.Println("Got sweeper ID:", cookie.Value)
+		log.Println("Got sweeper ID:", id)
+	}
+	if rotate {
+		s.setSessionCookie(w, r, id)
 	}
 
-	if strings.HasPrefix(r.URL.Path, "/admin") && !isAdminUser(cookie.Value) {
+	if strings.HasPrefix(r.URL.Path, "/admin") && (err != nil || !isAdminUser(id)) {
 		w.WriteHeader(http.StatusForbidden)
 		fmt.Fprintf(w, "Denied.\n")
 		return
@@ -46,10 +47,32 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
+	telnetAddr := flag.String("telnet", "", "address to serve the plain text protocol on, disabled if empty")
+	training := flag.Bool("training", false, "allow players to request mine probabilities for their viewport")
+	hintCost := flag.Uint("hint-cost", _defaultHintCost, "number of points deducted for a hint")
+	rules := flag.String("rules", "", "rules for the mine field, keeps the rules of the stored field if empty")
+	issueToken := flag.String("issue-token", "", "print a session token for the player with this ID and exit")
+	flag.Parse()
+
+	sessions, err := NewSessionsFromFile("session.key")
+	if err != nil {
+		log.Fatalln("can't load session secret:", err)
+	}
+	if *issueToken != "" {
+		fmt.Println(sessions.Issue(*issueToken))
+		return
+	}
+
 	m, err := NewMineField(4, "minefield.gob")
 	if err != nil {
 		log.Fatalln("can't create mine field:", err)
 	}
+	if *rules != "" {
+		err = m.SetRules(*rules)
+		if err != nil {
+			log.Fatalln("can't set rules:", err)
+		}
+	}
 
 	log.Println("Registering HTTP handlers")
 
@@ -57,9 +80,54 @@ func main() {
 	if err != nil {
 		log.Fatalln("can't create server:", err)
 	}
-	http.HandleFunc("/", handleIndex)
+	s.allowTraining = *training
+	s.hintCost = *hintCost
+	s.sessions = sessions
+
+	hooks, err := NewWebhooksFromFile("webhooks.json")
+	if err != nil {
+		log.Println("not sending webhooks:", err)
+	}
+	s.subscribeWebhooks(hooks)
+
+	achievements, err := NewAchievementsFromFile("achievements.json")
+	switch {
+	case os.IsNotExist(err):
+		log.Println("no achievements file, using default achievements")
+	case err != nil:
+		log.Fatalln("can't load achievements:", err)
+	default:
+		s.achievements = achievements
+	}
+
+	moderation, err := NewModerationFromFile("moderation.json")
+	switch {
+	case os.IsNotExist(err):
+		log.Println("no moderation file, not denying any names")
+	case err != nil:
+		log.Fatalln("can't load moderation settings:", err)
+	default:
+		s.moderation = moderation
+	}
+
+	http.HandleFunc("/", s.handleIndex)
 	http.HandleFunc("/ws", s.wsHandler)
 	http.HandleFunc("/admin", s.adminHandler)
+	http.HandleFunc("/contributions.png", s.contributionHandler)
+	http.HandleFunc("/feed", s.feedHandler)
+	http.HandleFunc("/achievements", s.achievementsHandler)
+	http.HandleFunc("/profile", s.profileHandler)
+	http.HandleFunc("/leaderboards", s.leaderboardsHandler)
+	http.HandleFunc("/account", s.accountHandler)
+
+	if *telnetAddr != "" {
+		go func() {
+			err := s.ServeTelnet(*telnetAddr)
+			if err != nil {
+				log.Fatalln("can't run telnet server:", err)
+			}
+		}()
+	}
 
 	log.Println("HTTP handler set up, listening on port 8080")
 
@@ -13,53 +13,11 @@ import (
 	"math/rand"
 	"os"
 	"sync"
-)
-
-type ViewPortElement rune
 
-const (
-	// TODO: Make some use of the zero value?
-
-	// Numbers
-	VPEZero ViewPortElement = '0' + iota
-	VPEOne
-	VPETwo
-	VPEThree
-	VPEFour
-	VPEFive
-	VPESix
-	VPESeven
-	VPEEight
-
-	// Others
-	VPENone  = ' '
-	VPEFlag  = 'P'
-	VPEMaybe = '?'
-	VPEMine  = 'X' // Only used for debugging (or some spectator mode?)
+	"github.com/farhaven/sweeper/protocol"
+	"github.com/farhaven/sweeper/solver"
 )
 
-func (ve ViewPortElement) String() string {
-	return fmt.Sprintf("%c", ve)
-}
-
-type ViewPort struct {
-	Position image.Rectangle
-	Data     [][]ViewPortElement
-}
-
-func NewViewPort(rect image.Rectangle) ViewPort {
-	width, height := rect.Dx(), rect.Dy()
-
-	res := ViewPort{}
-	res.Position = rect
-	res.Data = make([][]ViewPortElement, height)
-	for y := 0; y < height; y++ {
-		res.Data[y] = make([]ViewPortElement, width)
-	}
-
-	return res
-}
-
 // IntToBytes converts x to a little endian byte slice
 func IntToBytes(val int64) []byte {
 	x := uint64(val + math.MinInt64)
@@ -86,23 +44,52 @@ type MineField struct {
 
 	// Path from which the minefield is read on restart and to which it is saved on changes
 	persistencePath string
+	// bus on which changes to the field are published, if any
+	bus *Bus
 
 	Seed    [16]byte
 	Density uint32
+	// Name of the rules used for this field
+	RulesName string
 	// Map of coordinates to neighboring mine count
 	Uncovered map[image.Point]int
 	// Map of Triggered mines
 	Triggered map[image.Point]bool
 	// Map of Marks, i.e. flags and question Marks
 	Marks map[image.Point]Mark
+	// Map of uncovered coordinates to the ID of the player who uncovered them
+	Owners map[image.Point]string
+
+	// counts the changes to the fields visible to players, and its value at the last change of each chunk of the field, so that
+	// results derived from a part of the field can be cached until that part changes
+	generation       uint64
+	chunkGenerations map[image.Point]uint64
 }
 
+// Changes to the mine field are tracked per square chunk of this size
+const _generationChunk = 16
+
+// chunkOf returns the coordinates of the chunk that contains pt
+func chunkOf(pt image.Point) image.Point {
+	div := func(a int) int {
+		if a < 0 {
+			return (a+1)/_generationChunk - 1
+		}
+		return a / _generationChunk
+	}
+	return image.Pt(div(pt.X), div(pt.Y))
+}
+
+// NewMineField loads the mine field stored at persistencePath, or creates a fresh one if there is none. Fresh mine fields use the
+// default rules.
 func NewMineField(threshold uint32, persistencePath string) (*MineField, error) {
 	m := &MineField{
 		Density:         5,
+		RulesName:       _defaultRules,
 		Uncovered:       make(map[image.Point]int),
 		Triggered:       make(map[image.Point]bool),
 		Marks:           make(map[image.Point]Mark),
+		Owners:          make(map[image.Point]string),
 		persistencePath: persistencePath,
 	}
 
@@ -123,9 +110,50 @@ func NewMineField(threshold uint32, persistencePath string) (*MineField, error)
 	if err != nil {
 		return nil, fmt.Errorf("can't load minefield: %w", err)
 	}
+
+	// Mine fields persisted before ownership was tracked don't have an owner map
+	if m.Owners == nil {
+		m.Owners = make(map[image.Point]string)
+	}
+	// ... and mine fields persisted before rules were configurable use the original rules
+	if m.RulesName == "" {
+		m.RulesName = _defaultRules
+	}
 	return m, nil
 }
 
+// SetRules changes the rules used for m
+func (m *MineField) SetRules(name string) error {
+	_, err := RulesByName(name)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.RulesName = name
+	return nil
+}
+
+// Rules returns the rules used for m
+func (m *MineField) Rules() Rules {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.rules()
+}
+
+// rules is like Rules, but expects m.mu to be held
+func (m *MineField) rules() Rules {
+	r, err := RulesByName(m.RulesName)
+	if err != nil {
+		log.Println("can't get rules, using default:", err)
+		r, _ = RulesByName(_defaultRules)
+	}
+	return r
+}
+
 func (m *MineField) Persist() error {
 	log.Println("persisting minefield")
 
@@ -150,6 +178,11 @@ func (m *MineField) Persist() error {
 	return err
 }
 
+// MineProbability returns the probability of any single field containing a mine
+func (m *MineField) MineProbability() float64 {
+	return 1 / float64(m.Density)
+}
+
 // IsMineOnLocation returns true if there is a mine in the location indicated by x and y.
 func (m *MineField) IsMineOnLocation(x, y int) bool {
 	// Determine wether x, y contains a mine by hashing it with the seed and checking whether it's less than a threshold
@@ -162,8 +195,8 @@ func (m *MineField) IsMineOnLocation(x, y int) bool {
 
 // ExtractPlayerView returns a 2 dimensional array describing a players view of the field using the provided rectangle as a view
 // port. The returned array is in row-major order.
-func (m *MineField) ExtractPlayerView(viewport image.Rectangle) ViewPort {
-	res := NewViewPort(viewport)
+func (m *MineField) ExtractPlayerView(viewport image.Rectangle) protocol.ViewPort {
+	res := protocol.NewViewPort(viewport)
 
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -177,20 +210,20 @@ func (m *MineField) ExtractPlayerView(viewport image.Rectangle) ViewPort {
 
 			pt := image.Pt(x, y)
 			if m.IsMineOnLocation(x, y) && m.Triggered[pt] {
-				res.Data[ay][ax] = VPEMine
+				res.Data[ay][ax] = protocol.VPEMine
 			} else if m.Marks[pt] != MarkNone {
 				if m.Marks[pt] == MarkQuestion {
-					res.Data[ay][ax] = VPEMaybe
+					res.Data[ay][ax] = protocol.VPEMaybe
 				} else {
-					res.Data[ay][ax] = VPEFlag
+					res.Data[ay][ax] = protocol.VPEFlag
 				}
 			} else {
-				res.Data[ay][ax] = VPENone
+				res.Data[ay][ax] = protocol.VPENone
 			}
 
 			mines, ok := m.Uncovered[image.Pt(x, y)]
 			if ok {
-				res.Data[ay][ax] = ViewPortElement('0' + mines)
+				res.Data[ay][ax] = protocol.ViewPortElement('0' + mines)
 			}
 		}
 	}
@@ -198,6 +231,88 @@ func (m *MineField) ExtractPlayerView(viewport image.Rectangle) ViewPort {
 	return res
 }
 
+// ExtractOwnership returns a 2 dimensional array in row-major order that indicates which fields in the viewport have been
+// uncovered by the player identified by owner.
+func (m *MineField) ExtractOwnership(viewport image.Rectangle, owner string) [][]bool {
+	res := make([][]bool, viewport.Dy())
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for y := viewport.Min.Y; y < viewport.Max.Y; y++ {
+		ay := y - viewport.Min.Y
+		res[ay] = make([]bool, viewport.Dx())
+		for x := viewport.Min.X; x < viewport.Max.X; x++ {
+			res[ay][x-viewport.Min.X] = m.Owners[image.Pt(x, y)] == owner
+		}
+	}
+
+	return res
+}
+
+// ReassignOwner attributes all fields uncovered by the player identified by from to the player identified by to
+func (m *MineField) ReassignOwner(from, to string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for pt, owner := range m.Owners {
+		if owner == from {
+			m.Owners[pt] = to
+		}
+	}
+}
+
+// VisibleBoard returns the state of the area of m indicated by rect as it is visible to players. It doesn't reveal the location of
+// mines that haven't been triggered, so it is safe to use for deductions that players could make themselves.
+func (m *MineField) VisibleBoard(rect image.Rectangle) solver.Board {
+	b := solver.NewBoard(rect)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			pt := image.Pt(x, y)
+			if mines, ok := m.Uncovered[pt]; ok {
+				b.Numbers[pt] = mines
+			} else if m.Triggered[pt] {
+				b.Mines[pt] = true
+			} else if m.Marks[pt] == MarkFlag {
+				b.Flags[pt] = true
+			}
+		}
+	}
+
+	return b
+}
+
+// Generation returns a number that changes whenever a field in rect is uncovered, triggered or marked. Changes elsewhere may
+// change it as well if they are close to rect.
+func (m *MineField) Generation(rect image.Rectangle) uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	min, max := chunkOf(rect.Min), chunkOf(rect.Max.Sub(image.Pt(1, 1)))
+	var res uint64
+	for y := min.Y; y <= max.Y; y++ {
+		for x := min.X; x <= max.X; x++ {
+			if gen := m.chunkGenerations[image.Pt(x, y)]; gen > res {
+				res = gen
+			}
+		}
+	}
+	return res
+}
+
+// changed records that the field at pt changed. m must be locked for writing.
+func (m *MineField) changed(pt image.Point) {
+	if m.chunkGenerations == nil {
+		m.chunkGenerations = make(map[image.Point]uint64)
+	}
+	m.generation++
+	m.chunkGenerations[chunkOf(pt)] = m.generation
+}
+
 // CountNeighboringMines returns the number of mines bordering on the field identified by x, y
 func (m *MineField) CountNeighboringMines(x int, y int) int {
 	mines := 0
@@ -209,16 +324,32 @@ func (m *MineField) CountNeighboringMines(x int, y int) int {
 	return mines
 }
 
-// Mark cycles the mark at location x, y between "None", "Questionable", "Flagged"
-//
-// It locks m for writing
-func (m *MineField) Mark(x int, y int) {
+// setBus sets the bus on which changes to the field are published
+func (m *MineField) setBus(bus *Bus) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// Only set marks on fields that have not been uncovered or triggered
+	m.bus = bus
+}
+
+// Mark cycles the mark on the field at x, y on behalf of the player with the ID owner and publishes a MarkChanged event
+func (m *MineField) Mark(x int, y int, owner string) {
+	m.mu.Lock()
 	pt := image.Pt(x, y)
+	_, isUncovered := m.Uncovered[pt]
+	if !m.rules().AllowMark(isUncovered || m.Triggered[pt]) {
+		m.mu.Unlock()
+		log.Printf("not marking %s", pt)
+		return
+	}
+
 	m.Marks[pt] = (m.Marks[pt] + 1) % MarkMax
+	m.changed(pt)
+	mark := m.Marks[pt]
+	bus := m.bus
+	m.mu.Unlock()
+
+	bus.Publish(MarkChanged{PlayerID: owner, At: pt, Mark: mark})
 }
 
 type UncoverResult int
@@ -226,16 +357,36 @@ type UncoverResult int
 const (
 	UncoverMiss = iota
 	UncoverBoom
+	UncoverNothing // The field was already uncovered or triggered
 )
 
-// Uncover reveals the field at location x, y. It returns an UncoverResult that indicates whether an explosion was triggered and a
-// score, based on the number of fields that were revealed and their value.
+// Uncover reveals the field at location x, y on behalf of the player identified by owner. It returns an UncoverResult that
+// indicates whether an explosion was triggered, a score, based on the number of fields that were revealed and the points the
+// rules of m award for them, and the number of fields that were revealed.
 //
 // If the uncovered field has no neighboring mines, it uses a flood-fill algorithm to uncover neighboring cells until a "border" of
-// mines is reached, or until the newly uncovered field is more than 30 fields distant from (x, y).
+// mines is reached, or until the newly uncovered field is further from (x, y) than the flood fill radius of the rules of m.
 //
-// Uncover locks m for writing.
-func (m *MineField) Uncover(x int, y int) (UncoverResult, int) {
+// Uncover locks m for writing. Once the lock is released, the outcome is published as a CellsUncovered or MineTriggered event.
+func (m *MineField) Uncover(x int, y int, owner string) (UncoverResult, int, int) {
+	result, points, revealed := m.uncover(x, y, owner)
+
+	m.mu.RLock()
+	bus := m.bus
+	m.mu.RUnlock()
+
+	switch result {
+	case UncoverMiss:
+		bus.Publish(CellsUncovered{PlayerID: owner, At: image.Pt(x, y), Points: points, Revealed: revealed})
+	case UncoverBoom:
+		bus.Publish(MineTriggered{PlayerID: owner, At: image.Pt(x, y)})
+	}
+
+	return result, points, revealed
+}
+
+// uncover does the work for Uncover without publishing events
+func (m *MineField) uncover(x int, y int, owner string) (UncoverResult, int, int) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -245,9 +396,12 @@ func (m *MineField) Uncover(x int, y int) (UncoverResult, int) {
 	_, isUncovered := m.Uncovered[point]
 	if m.Triggered[point] || isUncovered {
 		log.Printf("not doing anything for %s", point)
-		return UncoverMiss, 0
+		return UncoverNothing, 0, 0
 	}
 
+	// Everything below changes what players see
+	m.changed(point)
+
 	// Remove location from list of marked points
 	delete(m.Marks, point)
 
@@ -257,23 +411,26 @@ func (m *MineField) Uncover(x int, y int) (UncoverResult, int) {
 	if m.IsMineOnLocation(x, y) {
 		m.Triggered[point] = true
 		log.Println("BOOM", x, y)
-		return UncoverBoom, 0
+		return UncoverBoom, 0, 0
 	}
 
 	mines := m.CountNeighboringMines(x, y)
 
-	// Count of uncovered fields
-	score := mines
+	score := int(m.rules().CellPoints(mines))
+
+	log.Printf("neighboring mines for x=%d, y=%d: %d", x, y, mines)
+	m.Uncovered[point] = mines
+	m.Owners[point] = owner
 
 	// If there are no mines in the vicinity, uncover fields until a "border" of mines is reached.
+	revealed := 1
 	if mines == 0 {
-		score += m.FloodFill(x, y)
+		points, cells := m.FloodFill(x, y, owner)
+		score += points
+		revealed += cells
 	}
 
-	log.Printf("neighboring mines for x=%d, y=%d: %d", x, y, mines)
-	m.Uncovered[point] = mines
-
-	return UncoverMiss, score
+	return UncoverMiss, score, revealed
 }
 
 // Neighbors returns the 8 points around p.
@@ -298,9 +455,12 @@ func (m *MineField) Neighbors(p image.Point) [8]image.Point {
 	return res
 }
 
-// FloodFill starts a flood filling operation centered on x and y, uncovering fields without mines for a limited radius
-func (m *MineField) FloodFill(x int, y int) int {
-	const maxRadius = 30 // Maximum uncovering distance
+// FloodFill starts a flood filling operation centered on x and y, uncovering fields without mines for a limited radius. Newly
+// uncovered fields are attributed to owner. It returns the points the rules of m award for the newly uncovered fields and the
+// number of newly uncovered fields.
+func (m *MineField) FloodFill(x int, y int, owner string) (int, int) {
+	rules := m.rules()
+	maxRadius := rules.FloodFillRadius() // Maximum uncovering distance
 
 	center := image.Pt(x, y)
 	dist := func(p image.Point) float64 {
@@ -309,7 +469,7 @@ func (m *MineField) FloodFill(x int, y int) int {
 		return d
 	}
 
-	var numUncovered int
+	var points, cells int
 	alreadyHandled := make(map[image.Point]bool)
 	uncovered := make(map[image.Point]int)
 	unhandled := make(map[image.Point]bool)
@@ -350,11 +510,14 @@ func (m *MineField) FloodFill(x int, y int) int {
 			// Already uncovered by someone else
 			continue
 		}
-		numUncovered += mines
+		points += int(rules.CellPoints(mines))
+		cells++
 		m.Uncovered[pt] = mines
+		m.Owners[pt] = owner
+		m.changed(pt)
 	}
 
-	return numUncovered
+	return points, cells
 }
 
 const _zoom = 32
@@ -393,3 +556,53 @@ func (m *MineField) RenderToImage(rect image.Rectangle) image.Image {
 
 	return img
 }
+
+// OwnerColor returns a stable color for the given owner ID. Fields without an owner are rendered white.
+func OwnerColor(owner string) color.Color {
+	if owner == "" {
+		return color.White
+	}
+
+	h := fnv.New32()
+	h.Write([]byte(owner))
+	sum := h.Sum32()
+
+	// Keep the channels away from both black and white so that grid lines and unowned fields stay distinguishable
+	return color.RGBA{
+		R: byte(64 + sum%160),
+		G: byte(64 + (sum>>8)%160),
+		B: byte(64 + (sum>>16)%160),
+		A: 0xff,
+	}
+}
+
+// Size of a field in contribution images, in pixels. Contribution maps cover large areas, so they are rendered much smaller than
+// RenderToImage renders fields.
+const _contributionZoom = 4
+
+// RenderContributionImage returns an image of the area of the mine field m indicated by rect in which each uncovered field is
+// colored according to the player who uncovered it. The colors are determined by calling colorOf with the owner ID of a field, so
+// callers can group several players (for example, a team) under the same color. Each field is _contributionZoom pixels wide and
+// high, without grid lines.
+func (m *MineField) RenderContributionImage(rect image.Rectangle, colorOf func(owner string) color.Color) image.Image {
+	const z = _contributionZoom
+	img := image.NewRGBA(image.Rect(rect.Min.X*z, rect.Min.Y*z, rect.Max.X*z, rect.Max.Y*z))
+
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.ZP, draw.Src)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			owner, ok := m.Owners[image.Pt(x, y)]
+			if !ok {
+				continue
+			}
+			r := image.Rect(x*z, y*z, (x+1)*z, (y+1)*z)
+			draw.Draw(img, r, &image.Uniform{colorOf(owner)}, image.ZP, draw.Src)
+		}
+	}
+
+	return img
+}
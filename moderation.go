@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/farhaven/sweeper/protocol"
+	"golang.org/x/time/rate"
+)
+
+// Maximum length of a name, in runes
+const _maxNameLen = 32
+
+// Players may rename themselves _renameBurst times in a row, after that once per _renameInterval
+const _renameInterval = 10 * time.Minute
+const _renameBurst = 3
+
+// Names chosen by an admin can't be changed by the player for _forcedNameLock unless the admin asks for a different period
+const _forcedNameLock = 7 * 24 * time.Hour
+
+// Maximum number of reported names waiting for an admin. The oldest reports are dropped when more names are reported.
+const _maxReports = 200
+
+var errNameTooLong = fmt.Errorf("names can be at most %d characters long", _maxNameLen)
+var errInvalidName = errors.New("names can only contain printable characters")
+var errDeniedName = errors.New("that name isn't allowed")
+var errRenameTooOften = errors.New("you're changing your name too often, try again later")
+var errNoSuchPlayer = errors.New("there is no such player")
+
+// _lookalikes lists characters that look like or are commonly used in place of a letter. Names are compared against the deny list
+// with all of them replaced by that letter. The letter l is folded into i, since both look like 1 and |.
+var _lookalikes = map[rune]string{
+	'a': "àáâãäåāăąǎаα@4ª",
+	'b': "вβ8ß",
+	'c': "çćĉċčсς¢(",
+	'd': "ďđԁ",
+	'e': "èéêëēĕėęěеёεє€3",
+	'g': "ĝğġģɡ9",
+	'h': "ĥħнһ#",
+	'i': "ìíîïĩīĭįıіїιl1|!¡ĺļľłӏ",
+	'j': "ĵј",
+	'k': "ķкκ",
+	'm': "м",
+	'n': "ñńņňηпŋ",
+	'o': "òóôõöøōŏőоοσ0°º",
+	'p': "рρ",
+	'r': "ŕŗřг",
+	's': "śŝşšѕ$5§",
+	't': "ţťŧтτ7+",
+	'u': "ùúûüũūŭůűųυμ",
+	'v': "ν",
+	'w': "ŵѡω",
+	'x': "хχ×",
+	'y': "ýÿŷуγ",
+	'z': "źżž2",
+}
+
+var _lookalikeMap = func() map[rune]rune {
+	res := make(map[rune]rune)
+	for letter, chars := range _lookalikes {
+		for _, r := range chars {
+			res[r] = letter
+		}
+	}
+	return res
+}()
+
+// normalizeName returns the letters of name in lower case, with lookalike characters replaced by the letters they resemble.
+// Everything else, including spaces, punctuation and invisible characters, is dropped, so that separating the letters of a word
+// doesn't hide it.
+func normalizeName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		// Fullwidth forms of ASCII characters
+		if r >= '！' && r <= '～' {
+			r -= '！' - '!'
+		}
+		r = unicode.ToLower(r)
+		if letter, ok := _lookalikeMap[r]; ok {
+			r = letter
+		}
+		if unicode.IsLetter(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Moderation decides which names players may choose. Names containing one of the DeniedWords are refused. Both the names and the
+// words are normalized with normalizeName before comparing them, so the words should be long enough to not appear in harmless
+// names by accident.
+type Moderation struct {
+	DeniedWords []string
+
+	// normalized forms of DeniedWords
+	denied []string
+}
+
+// NewModerationFromFile reads the deny list from the JSON file at path
+func NewModerationFromFile(path string) (Moderation, error) {
+	var m Moderation
+
+	fh, err := os.Open(path)
+	if err != nil {
+		return m, err
+	}
+	defer fh.Close()
+
+	dec := json.NewDecoder(fh)
+	err = dec.Decode(&m)
+	if err != nil {
+		return m, err
+	}
+
+	for _, word := range m.DeniedWords {
+		normalized := normalizeName(word)
+		if normalized == "" {
+			return m, fmt.Errorf("denied word %q contains no letters", word)
+		}
+		m.denied = append(m.denied, normalized)
+	}
+
+	return m, nil
+}
+
+// Check returns name with surrounding white space removed, or an error if players may not be called that
+func (m Moderation) Check(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errEmptyName
+	}
+	if utf8.RuneCountInString(name) > _maxNameLen {
+		return "", errNameTooLong
+	}
+	for _, r := range name {
+		if !unicode.IsPrint(r) {
+			return "", errInvalidName
+		}
+	}
+
+	normalized := normalizeName(name)
+	for _, word := range m.denied {
+		if strings.Contains(normalized, word) {
+			return "", errDeniedName
+		}
+	}
+
+	return name, nil
+}
+
+// allowRename returns an error if the player may not change their name now. Must be called with p.mu held for writing.
+func (p *Player) allowRename() error {
+	if time.Now().Before(p.NameLockedUntil) {
+		return fmt.Errorf("your name was chosen by an admin, you can change it after %s",
+			p.NameLockedUntil.UTC().Format(time.RFC1123))
+	}
+
+	if p.renameLimit == nil {
+		p.renameLimit = rate.NewLimiter(rate.Every(_renameInterval), _renameBurst)
+	}
+	if !p.renameLimit.Allow() {
+		return errRenameTooOften
+	}
+
+	return nil
+}
+
+// NameReport is a name reported by players, waiting for an admin to dismiss it or to rename the player
+type NameReport struct {
+	PlayerID  string
+	Name      string
+	Reporters []string
+	Reported  time.Time
+}
+
+// reportName reports the name of the player called name to the admins on behalf of p
+func (p *Player) reportName(name string) {
+	target := p.s.FindPlayer(name)
+	if target == nil {
+		p.notifyError(fmt.Sprintf("There is no player called %s", name))
+		return
+	}
+	if target == p {
+		p.notifyError("You can't report yourself")
+		return
+	}
+
+	reported := target.displayName()
+	p.s.addReport(target.Id, reported, p.Id)
+	p.notify(protocol.Event{
+		Event:    protocol.EventReported,
+		Reported: reported,
+	})
+
+	p.s.bus.Publish(NameReported{PlayerID: p.Id, TargetID: target.Id, Name: reported})
+}
+
+// addReport adds reporter to the reports of the name of the player with the given ID
+func (s *Server) addReport(id, name, reporter string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.Reports {
+		r := &s.Reports[i]
+		if r.PlayerID != id || r.Name != name {
+			continue
+		}
+		for _, other := range r.Reporters {
+			if other == reporter {
+				return
+			}
+		}
+		r.Reporters = append(r.Reporters, reporter)
+		return
+	}
+
+	log.Printf("name %q of player %s was reported", name, id)
+	s.Reports = append(s.Reports, NameReport{
+		PlayerID:  id,
+		Name:      name,
+		Reporters: []string{reporter},
+		Reported:  time.Now(),
+	})
+	if len(s.Reports) > _maxReports {
+		s.Reports = s.Reports[len(s.Reports)-_maxReports:]
+	}
+}
+
+// GetReports returns the reported names, oldest first
+func (s *Server) GetReports() []NameReport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]NameReport{}, s.Reports...)
+}
+
+// DismissReports removes the reports about the player with the given ID. It returns false if there were none.
+func (s *Server) DismissReports(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := false
+	reports := s.Reports[:0]
+	for _, r := range s.Reports {
+		if r.PlayerID == id {
+			found = true
+			continue
+		}
+		reports = append(reports, r)
+	}
+	s.Reports = reports
+
+	return found
+}
+
+// ForceRename changes the name of the player with the given ID on behalf of an admin and dismisses the reports about them. If
+// name is empty, the player gets a generated name. The player can't change the name for the given period, or for
+// _forcedNameLock if it is zero.
+func (s *Server) ForceRename(id, name string, lock time.Duration) error {
+	if name != "" {
+		var err error
+		name, err = s.moderation.Check(name)
+		if err != nil {
+			return err
+		}
+	}
+	if lock == 0 {
+		lock = _forcedNameLock
+	}
+
+	s.mu.Lock()
+	p := s.Players[id]
+	if p == nil {
+		s.mu.Unlock()
+		return errNoSuchPlayer
+	}
+	if name == "" {
+		name = s.uniqueName(id)
+	} else if s.nameTaken(name, id) {
+		s.mu.Unlock()
+		return errNameTaken
+	}
+
+	p.mu.Lock()
+	oldName := p.Name
+	p.Name = name
+	p.NameLockedUntil = time.Now().Add(lock)
+	p.mu.Unlock()
+	s.mu.Unlock()
+
+	s.DismissReports(id)
+	log.Printf("admin renamed player %s from %q to %q", id, oldName, name)
+
+	if oldName != name {
+		s.bus.Publish(PlayerRenamed{PlayerID: id, OldName: oldName, NewName: name})
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// Players get a generated name made of an adjective and a noun when they are created, so that they can be told apart on the
+// leaderboards before they choose a name themselves
+var _nameAdjectives = []string{
+	"Agile", "Bold", "Brave", "Bright", "Calm", "Clever", "Cosmic", "Curious", "Daring", "Eager",
+	"Fearless", "Fuzzy", "Gentle", "Glad", "Golden", "Happy", "Jolly", "Keen", "Kind", "Lucky",
+	"Mellow", "Mighty", "Nimble", "Noble", "Patient", "Plucky", "Quick", "Quiet", "Rapid", "Silent",
+	"Sly", "Snappy", "Steady", "Sunny", "Swift", "Tidy", "Trusty", "Velvet", "Witty", "Zesty",
+}
+
+var _nameNouns = []string{
+	"Badger", "Beaver", "Bison", "Crane", "Dingo", "Dolphin", "Falcon", "Ferret", "Finch", "Fox",
+	"Gecko", "Heron", "Ibex", "Jackal", "Koala", "Lemur", "Lynx", "Marmot", "Marten", "Mole",
+	"Moose", "Newt", "Ocelot", "Otter", "Owl", "Panda", "Puffin", "Quokka", "Raven", "Robin",
+	"Salmon", "Seal", "Sparrow", "Stoat", "Tapir", "Toad", "Walrus", "Weasel", "Wombat", "Yak",
+}
+
+var errNameTaken = errors.New("that name is already taken")
+var errEmptyName = errors.New("names can't be empty")
+
+// generatedName returns a name for the player with the given ID. The same ID always results in the same name. If n is larger than
+// one, the name is numbered, to tell it apart from names that are already taken.
+func generatedName(id string, n int) string {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	sum := h.Sum64()
+
+	adjective := _nameAdjectives[sum%uint64(len(_nameAdjectives))]
+	noun := _nameNouns[(sum/uint64(len(_nameAdjectives)))%uint64(len(_nameNouns))]
+	if n > 1 {
+		return fmt.Sprintf("%s %s %d", adjective, noun, n)
+	}
+	return adjective + " " + noun
+}
+
+// nameTaken returns true if a player other than the one with the given ID is called name. Names are compared without regard to
+// case. Must be called with s.mu held.
+func (s *Server) nameTaken(name, id string) bool {
+	for otherID, p := range s.Players {
+		if otherID == id {
+			continue
+		}
+
+		p.mu.RLock()
+		taken := strings.EqualFold(p.Name, name)
+		p.mu.RUnlock()
+
+		if taken {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueName returns a generated name for the player with the given ID that no other player is called. Must be called with s.mu
+// held.
+func (s *Server) uniqueName(id string) string {
+	for n := 1; ; n++ {
+		name := generatedName(id, n)
+		if !s.nameTaken(name, id) {
+			return name
+		}
+	}
+}
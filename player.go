@@ -1,44 +1,98 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"image"
 	"log"
-	"math/rand"
+	"math"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/farhaven/sweeper/protocol"
+	"github.com/farhaven/sweeper/solver"
 	"github.com/gorilla/websocket"
 	"golang.org/x/time/rate"
 )
 
-type ClientRequest struct {
-	Kind string // kind of request: 'move', 'uncover', 'mark', 'update-name'
-	X, Y int    // parameters: deltaX, deltaY for move, X and Y relative to viewport for click
-	Name string // new name
-}
-
 const _viewPortWidth = 20
 const _viewPortHeight = 20
-const _maxNameLen = 32
 
 type Player struct {
 	mu       sync.RWMutex
 	s        *Server
 	Viewport image.Rectangle
 	Score    uint64
-	Id       string
-	Name     string
+	// Number of consecutive safe uncovers since the last explosion, and the longest such streak
+	Streak     uint64
+	BestStreak uint64
+	// Lives left in the current run, and the time until which the player can't uncover or mark fields after an explosion
+	Lives         uint
+	CooldownUntil time.Time
+	Id            string
+	Name          string
+	IsBot         bool
+	// whether the player was issued a session token, after which the plain ID isn't accepted as a cookie anymore
+	HasSession bool
+	// username of the account of the player, empty for guests
+	Account string
+	// time until which the player can't change the name an admin gave them
+	NameLockedUntil time.Time
+	// Counters about the actions of the player and the achievements they earned, by ID
+	Stats        PlayerStats
+	Achievements map[string]time.Time
+
+	// whether the player wants to see which fields they uncovered themselves
+	showContribution bool
+	// whether the player wants to see mine probabilities for the covered fields in their viewport
+	showProbabilities bool
+	// mine probabilities computed for the last viewport the player wanted to see them for
+	probabilities probabilityCache
+	// whether the current streak has already been announced as a record
+	recordAnnounced bool
+	// limits how often the player may change their name
+	renameLimit *rate.Limiter
 }
 
+// NewPlayer returns a new player with the given ID and a generated name that no other player has. Must be called with s.mu held.
 func NewPlayer(s *Server, id string) *Player {
 	log.Println("Player with ID", id, "connected")
-	x, y := int(rand.NormFloat64()*100), int(rand.NormFloat64()*100)
 	return &Player{
 		s:        s,
-		Viewport: image.Rect(-_viewPortWidth/2+x, -_viewPortHeight/2+y, _viewPortWidth/2+x, _viewPortHeight/2+y),
+		Viewport: spawnViewport(),
 		Id:       id,
+		Name:     s.uniqueName(id),
+		Lives:    s.m.Rules().Lives(),
+	}
+}
+
+// persistentCopy returns a copy of the persisted fields of p
+func (p *Player) persistentCopy() *Player {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	achievements := make(map[string]time.Time, len(p.Achievements))
+	for id, earned := range p.Achievements {
+		achievements[id] = earned
+	}
+
+	return &Player{
+		Viewport:        p.Viewport,
+		Score:           p.Score,
+		Streak:          p.Streak,
+		BestStreak:      p.BestStreak,
+		Lives:           p.Lives,
+		CooldownUntil:   p.CooldownUntil,
+		Id:              p.Id,
+		Name:            p.Name,
+		IsBot:           p.IsBot,
+		HasSession:      p.HasSession,
+		Account:         p.Account,
+		NameLockedUntil: p.NameLockedUntil,
+		Stats:           p.Stats.copy(),
+		Achievements:    achievements,
 	}
 }
 
@@ -46,14 +100,49 @@ func (p *Player) String() string {
 	return fmt.Sprintf("%s(%s)@%s/%d", p.Id, p.Name, p.Viewport, p.Score)
 }
 
-func (p *Player) setName(name string) {
+// displayName returns the name of the player as shown to others
+func (p *Player) displayName() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.Name == "" {
+		return _anonName
+	}
+	return p.Name
+}
+
+// isBot returns true if the player is controlled by a registered bot
+func (p *Player) isBot() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.IsBot
+}
+
+// setName changes the name of the player. It fails if the name doesn't pass moderation, if another player is already called
+// that, ignoring case, if an admin chose the current name recently or if the player changes their name too often.
+func (p *Player) setName(name string) error {
+	name, err := p.s.moderation.Check(name)
+	if err != nil {
+		return err
+	}
+
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+
+	if p.s.nameTaken(name, p.Id) {
+		return errNameTaken
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	if len(name) > _maxNameLen {
-		name = name[:_maxNameLen] + " ..."
+	err = p.allowRename()
+	if err != nil {
+		return err
 	}
 	p.Name = name
+	return nil
 }
 
 func (p *Player) setServer(s *Server) {
@@ -63,7 +152,7 @@ func (p *Player) setServer(s *Server) {
 	p.s = s
 }
 
-func (p *Player) mapViewport(req ClientRequest) (int, int) {
+func (p *Player) mapViewport(req protocol.ClientRequest) (int, int) {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
@@ -80,6 +169,79 @@ func (p *Player) shiftViewport(deltaX int, deltaY int) {
 	p.Viewport.Max.Y += deltaY
 }
 
+func (p *Player) getViewport() image.Rectangle {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.Viewport
+}
+
+func (p *Player) toggleContribution() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.showContribution = !p.showContribution
+}
+
+func (p *Player) toggleProbabilities() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.showProbabilities = !p.showProbabilities
+}
+
+// probabilityCache holds the mine probabilities of a viewport as computed at a generation of that part of the mine field
+type probabilityCache struct {
+	viewport   image.Rectangle
+	generation uint64
+	grid       [][]float64
+}
+
+// probabilityGrid returns the mine probabilities of the frontier fields in viewport in row-major order. Fields without a known
+// probability are set to -1. Computing the probabilities is expensive, so the result is reused until the viewport or the fields in
+// it change. p must not be locked.
+func (p *Player) probabilityGrid(viewport image.Rectangle) [][]float64 {
+	generation := p.s.m.Generation(viewport)
+
+	p.mu.RLock()
+	cache := p.probabilities
+	p.mu.RUnlock()
+
+	if cache.grid != nil && cache.viewport == viewport && cache.generation == generation {
+		return cache.grid
+	}
+
+	probs := solver.Probabilities(p.s.m.VisibleBoard(viewport), p.s.m.MineProbability())
+
+	res := make([][]float64, viewport.Dy())
+	for y := range res {
+		res[y] = make([]float64, viewport.Dx())
+		for x := range res[y] {
+			prob, ok := probs[viewport.Min.Add(image.Pt(x, y))]
+			if !ok {
+				res[y][x] = -1
+				continue
+			}
+			// Two decimal places are plenty for display and keep the update small
+			res[y][x] = math.Round(prob*100) / 100
+		}
+	}
+
+	p.mu.Lock()
+	p.probabilities = probabilityCache{viewport: viewport, generation: generation, grid: res}
+	p.mu.Unlock()
+
+	return res
+}
+
+// wantsProbabilities returns true if mine probabilities should be sent to the player
+func (p *Player) wantsProbabilities() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.showProbabilities && p.s.allowTraining
+}
+
 func (p *Player) incScore(delta uint) {
 	atomic.AddUint64(&p.Score, uint64(delta))
 }
@@ -93,64 +255,120 @@ func (p *Player) getScore() uint {
 	return uint(val)
 }
 
-// A state update contains the current score and the rendered viewpoint of a player, as well as the current high score list
-type StateUpdate struct {
-	Score      uint
-	Name       string
-	ViewPort   ViewPort
-	Highscores []HighscoreEntry
+// stateUpdate returns the current state as seen by p. The global leaderboards are only included if their version differs from
+// knownVersion, the version the receiver already has.
+func (p *Player) stateUpdate(knownVersion uint64) protocol.StateUpdate {
+	cooldown := p.cooldownRemaining()
+
+	// The leaderboards look up the names of other players, so they are collected before p is locked
+	region, regionScores := p.s.GetRegionScores(p.getViewport())
+	update := protocol.StateUpdate{
+		Region:              region,
+		RegionScores:        regionScores,
+		LeaderboardsVersion: p.s.leaderboardsVersion(),
+	}
+	if update.LeaderboardsVersion != knownVersion {
+		update.Highscores = p.s.GetHighscores()
+		update.BestScores = p.s.GetLeaderboard(BoardBest)
+		update.Daily = p.s.GetLeaderboard(BoardDaily)
+		update.Weekly = p.s.GetLeaderboard(BoardWeekly)
+		update.BestStreaks = p.s.GetBestStreaks()
+		update.Runs = p.s.GetRuns()
+	}
+
+	// Probabilities are expensive to compute and must not hold up other users of p
+	viewport := p.getViewport()
+	var probabilities [][]float64
+	if p.wantsProbabilities() {
+		probabilities = p.probabilityGrid(viewport)
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	update.Score = p.getScore()
+	update.Name = p.Name
+	update.ViewPort = p.s.m.ExtractPlayerView(p.Viewport)
+	update.Streak = p.getStreak()
+	update.Multiplier = p.s.m.Rules().StreakMultiplier(p.getStreak())
+	update.Lives = p.Lives
+	update.Cooldown = cooldown.Seconds()
+	if p.showContribution {
+		update.Contribution = p.s.m.ExtractOwnership(p.Viewport, p.Id)
+	}
+	// The viewport may have moved while the probabilities were computed, the next update will have the right ones
+	if probabilities != nil && viewport == p.Viewport {
+		update.Probabilities = probabilities
+	}
+
+	return update
 }
 
-func (p *Player) Loop(conn *websocket.Conn) {
-	updateViewport := make(chan bool)
+// Loop handles requests from the player on conn and sends state updates back until the connection is closed. Requests are
+// throttled by requestLimit. Events for the player are sent on conn as they happen.
+func (p *Player) Loop(conn *websocket.Conn, requestLimit *rate.Limiter) {
+	since := p.connected()
+	defer p.disconnected(since)
+
+	// Buffered so that an update requested while the writer is busy sending an event isn't lost. Further requests are coalesced.
+	updateViewport := make(chan bool, 1)
 	p.s.AddUpdateChannel(updateViewport)
-	defer p.s.RemoveUpdateChannel(updateViewport)
-	defer close(updateViewport)
+	events := make(chan protocol.Event, _eventBuffer)
+	p.s.AddEventChannel(events, p)
+	defer func() {
+		// Unregister before closing so that no one sends on the closed channel
+		p.s.RemoveEventChannel(events)
+		p.s.RemoveUpdateChannel(updateViewport)
+		close(updateViewport)
+	}()
 	go func() {
 		// Rate limiter for updates
 		limit := rate.NewLimiter(3, 5)
+		// Version of the leaderboards the client has
+		var leaderboardsVersion uint64
 
-		for range updateViewport {
-			if !limit.Allow() {
-				log.Println("Not sending update, rate limit exceeded")
-			}
-			wr, err := conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				log.Println("can't get writer for websocket:", err)
-				return
-			}
-			enc := json.NewEncoder(wr)
-			p.mu.RLock()
-			update := StateUpdate{
-				Score:      p.getScore(),
-				Name:       p.Name,
-				ViewPort:   p.s.m.ExtractPlayerView(p.Viewport),
-				Highscores: p.s.GetHighscores(),
+		for {
+			var msg interface{}
+
+			select {
+			case _, ok := <-updateViewport:
+				if !ok {
+					return
+				}
+				if !limit.Allow() {
+					log.Println("Not sending update, rate limit exceeded")
+				}
+				update := p.stateUpdate(leaderboardsVersion)
+				leaderboardsVersion = update.LeaderboardsVersion
+				msg = update
+			case ev := <-events:
+				msg = ev
 			}
-			p.mu.RUnlock()
-			err = enc.Encode(update)
+
+			err := writeMessage(conn, msg)
 			if err != nil {
-				log.Println("Can't encode update:", err)
-				wr.Close()
+				log.Println("Can't send message:", err)
 				return
 			}
-			wr.Close()
 		}
 	}()
 	// immediately trigger update
 	updateViewport <- true
 
-	// TODO:
-	// - send events to user:
-	//   - game over (someone clicked on a mine)
-
 	for {
 		messageType, r, err := conn.NextReader()
 		if err != nil {
 			return
 		}
+
+		err = requestLimit.Wait(context.Background())
+		if err != nil {
+			log.Println("can't wait for request rate limit:", err)
+			return
+		}
+
 		log.Println("got message of type", messageType)
-		var req ClientRequest
+		var req protocol.ClientRequest
 		dec := json.NewDecoder(r)
 		err = dec.Decode(&req)
 		if err != nil {
@@ -158,59 +376,80 @@ func (p *Player) Loop(conn *websocket.Conn) {
 		}
 		log.Printf("got client request %#v", req)
 
-		// - handle user requests:
-		//   - move viewport
-		//   - click on field
-		switch req.Kind {
-		case "move":
-			p.shiftViewport(req.X, req.Y)
-			// Trigger local viewport update
-			select {
-			case updateViewport <- true:
-			default:
-			}
-			err = p.s.Persist()
-			if err != nil {
-				log.Println("can't persist player list:", err)
-			}
-		case "uncover":
-			result, uncovered := p.s.m.Uncover(p.mapViewport(req))
-			if result != UncoverBoom {
-				p.incScore(uint(uncovered))
-			} else {
-				// TODO: Notify player with a "BOOM" message or something
-				p.resetScore()
-			}
-			err = p.s.m.Persist()
-			if err != nil {
-				log.Println("can't persist minefield:", err)
-			}
-			err = p.s.Persist()
-			if err != nil {
-				log.Println("can't persist player list:", err)
-			}
-			// TODO: Only trigger updates in overlapping viewports
-			p.s.TriggerGlobalUpdate()
-		case "mark":
-			log.Println("mark request", req)
-			// TODO: Only trigger updates in overlapping viewports
-			p.s.m.Mark(p.mapViewport(req))
-			err = p.s.m.Persist()
-			if err != nil {
-				log.Println("can't persist minefield:", err)
-			}
-			p.s.TriggerGlobalUpdate()
-		case "update-name":
-			log.Println("updating player name to", req.Name)
-			p.setName(req.Name)
-			err = p.s.Persist()
-			if err != nil {
-				log.Println("can't persist player list:", err)
-			}
-			p.s.TriggerGlobalUpdate()
-		default:
-			log.Printf("invalid request: %#v", req)
+		err = p.HandleRequest(req, updateViewport)
+		if err != nil {
+			log.Println("can't handle request:", err)
 			return
 		}
 	}
 }
+
+// HandleRequest performs the action requested by req on behalf of p. Updates that only concern p are signalled on updateViewport,
+// which may be nil if the caller isn't interested in them. It returns an error if the request kind is unknown.
+func (p *Player) HandleRequest(req protocol.ClientRequest, updateViewport chan bool) error {
+	// Side effects such as persistence and updates for other players are handled by the subscribers of the server's bus.
+	// - handle user requests:
+	//   - move viewport
+	//   - click on field
+	switch req.Kind {
+	case protocol.KindMove:
+		p.shiftViewport(req.X, req.Y)
+		// Trigger local viewport update
+		select {
+		case updateViewport <- true:
+		default:
+		}
+		p.s.bus.Publish(PlayerMoved{PlayerID: p.Id, Delta: image.Pt(req.X, req.Y), Viewport: p.getViewport()})
+	case protocol.KindUncover:
+		if p.rejectDuringCooldown() {
+			break
+		}
+		x, y := p.mapViewport(req)
+		p.s.m.Uncover(x, y, p.Id)
+	case protocol.KindMark:
+		if p.rejectDuringCooldown() {
+			break
+		}
+		log.Println("mark request", req)
+		x, y := p.mapViewport(req)
+		p.s.m.Mark(x, y, p.Id)
+	case protocol.KindUpdateName:
+		log.Println("updating player name to", req.Name)
+		oldName := p.displayName()
+		err := p.setName(req.Name)
+		if err != nil {
+			p.notifyError(err.Error())
+			break
+		}
+		if newName := p.displayName(); newName != oldName {
+			p.s.bus.Publish(PlayerRenamed{PlayerID: p.Id, OldName: oldName, NewName: newName})
+		}
+	case protocol.KindToggleContribution:
+		p.toggleContribution()
+		select {
+		case updateViewport <- true:
+		default:
+		}
+	case protocol.KindHint:
+		p.requestHint()
+	case protocol.KindProfile:
+		p.requestProfile(req.Name)
+	case protocol.KindReport:
+		p.reportName(req.Name)
+	case protocol.KindToggleTraining:
+		if !p.s.allowTraining {
+			log.Println("training mode is disabled, ignoring request")
+			p.notifyError("Training mode is disabled on this server")
+			break
+		}
+		p.toggleProbabilities()
+		select {
+		case updateViewport <- true:
+		default:
+		}
+	default:
+		return fmt.Errorf("invalid request: %#v", req)
+	}
+
+	return nil
+}
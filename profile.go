@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/farhaven/sweeper/protocol"
+)
+
+// connected records that the player started a session and returns the time at which it started
+func (p *Player) connected() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	p.Stats.LastSeen = now
+	return now
+}
+
+// disconnected records that the session of the player that started at since ended
+func (p *Player) disconnected(since time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	p.Stats.Playtime += now.Sub(since)
+	p.Stats.LastSeen = now
+}
+
+// profile returns the public statistics of p
+func (p *Player) profile() protocol.Profile {
+	name := p.displayName()
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return protocol.Profile{
+		Name:              name,
+		Bot:               p.IsBot,
+		Score:             p.getScore(),
+		BestStreak:        p.getBestStreak(),
+		CellsUncovered:    p.Stats.CellsUncovered,
+		Booms:             p.Stats.Booms,
+		FlagsPlaced:       p.Stats.FlagsPlaced,
+		FlagsCorrect:      p.Stats.FlagsCorrect,
+		DistanceTravelled: p.Stats.DistanceTravelled,
+		MaxDistance:       p.Stats.MaxDistance,
+		Playtime:          p.Stats.Playtime.Seconds(),
+		LastSeen:          p.Stats.LastSeen,
+		Achievements:      len(p.Achievements),
+	}
+}
+
+// FindPlayer returns the player with the given display name, ignoring case, or nil if there is none
+func (s *Server) FindPlayer(name string) *Player {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, p := range s.Players {
+		if strings.EqualFold(p.displayName(), name) {
+			return p
+		}
+	}
+	return nil
+}
+
+// requestProfile sends the profile of the player with the given name to p, or the profile of p if name is empty
+func (p *Player) requestProfile(name string) {
+	other := p
+	if name != "" {
+		other = p.s.FindPlayer(name)
+	}
+	if other == nil {
+		p.notifyError(fmt.Sprintf("There is no player called %s", name))
+		return
+	}
+
+	profile := other.profile()
+	p.notify(protocol.Event{
+		Event:   protocol.EventProfile,
+		Profile: &profile,
+	})
+}
+
+// profileHandler serves the profile of the player given by the name parameter, or the profile of the player identified by the
+// cookie if there is no such parameter.
+func (s *Server) profileHandler(w http.ResponseWriter, r *http.Request) {
+	var p *Player
+	if name := r.URL.Query().Get("name"); name != "" {
+		p = s.FindPlayer(name)
+	} else {
+		p = s.playerFromRequest(r)
+	}
+	if p == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "no such player")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	err := enc.Encode(p.profile())
+	if err != nil {
+		log.Println("can't encode profile:", err)
+	}
+}
@@ -0,0 +1,287 @@
+// Package protocol defines the messages exchanged between sweeper clients and the server.
+//
+// Clients send ClientRequests as JSON encoded websocket messages. The server answers with StateUpdates whenever the state of the
+// mine field or the highscores change.
+package protocol
+
+import (
+	"fmt"
+	"image"
+	"strings"
+	"time"
+)
+
+// ViewPortElement is the state of a single field in a viewport as seen by a player
+type ViewPortElement rune
+
+const (
+	// TODO: Make some use of the zero value?
+
+	// Numbers
+	VPEZero ViewPortElement = '0' + iota
+	VPEOne
+	VPETwo
+	VPEThree
+	VPEFour
+	VPEFive
+	VPESix
+	VPESeven
+	VPEEight
+
+	// Others
+	VPENone  = ' '
+	VPEFlag  = 'P'
+	VPEMaybe = '?'
+	VPEMine  = 'X' // Only used for debugging (or some spectator mode?)
+)
+
+func (ve ViewPortElement) String() string {
+	return fmt.Sprintf("%c", ve)
+}
+
+// ViewPort is a rectangular section of the mine field as seen by a player. Data is in row-major order.
+type ViewPort struct {
+	Position image.Rectangle
+	Data     [][]ViewPortElement
+}
+
+// NewViewPort returns an empty viewport covering rect
+func NewViewPort(rect image.Rectangle) ViewPort {
+	width, height := rect.Dx(), rect.Dy()
+
+	res := ViewPort{}
+	res.Position = rect
+	res.Data = make([][]ViewPortElement, height)
+	for y := 0; y < height; y++ {
+		res.Data[y] = make([]ViewPortElement, width)
+	}
+
+	return res
+}
+
+// String renders vp as text, one line per row, using the ViewPortElement runes.
+func (vp ViewPort) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s\n", vp.Position)
+	for _, row := range vp.Data {
+		for _, elem := range row {
+			fmt.Fprintf(&b, "%c", elem)
+		}
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// Kinds of client requests
+const (
+	KindMove               = "move"
+	KindUncover            = "uncover"
+	KindMark               = "mark"
+	KindUpdateName         = "update-name"
+	KindToggleContribution = "toggle-contribution"
+	KindToggleTraining     = "toggle-training"
+	KindHint               = "hint"
+	KindProfile            = "profile"
+	KindReport             = "report"
+)
+
+// ClientRequest is sent from the client to the server to perform an action.
+type ClientRequest struct {
+	Kind string // kind of request, one of the Kind* constants
+	X, Y int    // parameters: deltaX, deltaY for move, X and Y relative to viewport for click
+	Name string // new name, or name of the player whose profile is requested or whose name is reported
+}
+
+// Move returns a request that shifts the viewport by dx, dy
+func Move(dx, dy int) ClientRequest {
+	return ClientRequest{Kind: KindMove, X: dx, Y: dy}
+}
+
+// Uncover returns a request that uncovers the field at x, y relative to the viewport
+func Uncover(x, y int) ClientRequest {
+	return ClientRequest{Kind: KindUncover, X: x, Y: y}
+}
+
+// Mark returns a request that cycles the mark on the field at x, y relative to the viewport
+func Mark(x, y int) ClientRequest {
+	return ClientRequest{Kind: KindMark, X: x, Y: y}
+}
+
+// UpdateName returns a request that changes the name of the player
+func UpdateName(name string) ClientRequest {
+	return ClientRequest{Kind: KindUpdateName, Name: name}
+}
+
+// GetProfile returns a request for the profile of the player called name, or of the requesting player if name is empty
+func GetProfile(name string) ClientRequest {
+	return ClientRequest{Kind: KindProfile, Name: name}
+}
+
+// ReportName returns a request that reports the name of the player called name to the admins
+func ReportName(name string) ClientRequest {
+	return ClientRequest{Kind: KindReport, Name: name}
+}
+
+// HighscoreEntry is an entry in the highscores table
+type HighscoreEntry struct {
+	Name  string
+	Score uint
+	Bot   bool `json:",omitempty"` // set if the entry belongs to a bot
+}
+
+// Hint is the answer to a hint request, sent as an event. If a field could be deduced, Deduced is set, X and Y are its coordinates
+// relative to the viewport and Mine tells whether it is safe or a mine. Cost is the number of points that were deducted for the
+// hint.
+type Hint struct {
+	Deduced bool
+	X, Y    int
+	Mine    bool
+	Cost    uint
+	Message string
+}
+
+// A state update contains the current score and the rendered viewpoint of a player, as well as the current high score list and
+// the list of the longest streaks.
+// The global leaderboards (Highscores, BestScores, Daily, Weekly, BestStreaks and Runs) change rarely, so they are only sent if
+// LeaderboardsVersion differs from the version in the previous update sent on the same connection. Otherwise they are left out and
+// the receiver should keep the ones it already has.
+// If the player asked for it, it also contains a map of the fields in the viewport that were uncovered by the player and, in
+// training mode, the probability of each covered field on the frontier containing a mine (-1 for fields without a probability).
+type StateUpdate struct {
+	Score               uint
+	Name                string
+	ViewPort            ViewPort
+	LeaderboardsVersion uint64
+	Highscores          []HighscoreEntry `json:",omitempty"`
+	BestScores          []HighscoreEntry `json:",omitempty"` // Best scores ever reached
+	Daily               []HighscoreEntry `json:",omitempty"` // Points earned today
+	Weekly              []HighscoreEntry `json:",omitempty"` // Points earned this week
+	Streak              uint             // Number of consecutive safe uncovers
+	Multiplier          float64          // Factor applied to points because of the streak
+	BestStreaks         []HighscoreEntry `json:",omitempty"`
+	Runs                []HighscoreEntry `json:",omitempty"` // Best finished runs
+	Region              string           // Name of the region the viewport is in
+	RegionScores        []HighscoreEntry // Points earned in that region
+	Lives               uint             // Lives left in the current run
+	Cooldown            float64          // Seconds until the player may uncover or mark fields again
+	Contribution        [][]bool         `json:",omitempty"`
+	Probabilities       [][]float64      `json:",omitempty"`
+}
+
+// Kinds of events
+const (
+	EventBoom        = "boom"
+	EventScore       = "score"
+	EventCooldown    = "cooldown"
+	EventHint        = "hint"
+	EventError       = "error"
+	EventFeed        = "feed"
+	EventAchievement = "achievement"
+	EventProfile     = "profile"
+	EventReported    = "reported"
+)
+
+// Event is sent from the server to the client when something happens that isn't part of the regular state, for example an
+// explosion. Events are sent as separate messages on the same connection as state updates. They can be told apart from state
+// updates by the Event field, which is always set to one of the Event* constants. Depending on the kind of the event, one of the
+// other fields is set.
+//
+// Requests to uncover or mark fields during a cooldown are answered with an EventCooldown only. No state update is sent for them,
+// so clients must not wait for one after such a request.
+type Event struct {
+	Event       string
+	Boom        *Boom        `json:",omitempty"`
+	Score       *ScoreChange `json:",omitempty"`
+	Cooldown    float64      `json:",omitempty"` // Seconds until the player may uncover or mark fields again
+	Hint        *Hint        `json:",omitempty"`
+	Error       string       `json:",omitempty"`
+	Feed        *FeedEntry   `json:",omitempty"`
+	Achievement *Achievement `json:",omitempty"`
+	Profile     *Profile     `json:",omitempty"`
+	Reported    string       `json:",omitempty"` // Name that was reported to the admins on behalf of the player
+}
+
+// Boom describes an explosion at the world coordinates X, Y, triggered by the player called Player. It is sent to the player who
+// triggered it, with Own set, and to players whose viewport is nearby.
+//
+// The remaining fields are only set for the player who triggered the explosion. Forced is set if the player had no provably safe
+// alternative, in which case a reduced penalty was applied. Penalty is the number of points the player lost. LifeLost is set if
+// the explosion cost a life, Lives is the number of lives left afterwards and Cooldown the number of seconds the player can't
+// uncover or mark fields. RunEnded is set if the player lost their last life, in which case the score was archived and reset and
+// the player got a fresh set of lives.
+type Boom struct {
+	X, Y     int
+	Player   string
+	Own      bool
+	Forced   bool    `json:",omitempty"`
+	Penalty  uint    `json:",omitempty"`
+	LifeLost bool    `json:",omitempty"`
+	Lives    uint    `json:",omitempty"`
+	Cooldown float64 `json:",omitempty"`
+	RunEnded bool    `json:",omitempty"`
+}
+
+// Kinds of feed entries
+const (
+	FeedBoom      = "boom"
+	FeedFloodFill = "flood-fill"
+	FeedLeader    = "leader"
+	FeedRename    = "rename"
+	FeedRecord    = "record"
+)
+
+// FeedEntry is a notable event in the global event feed. Location is the world coordinate where it happened, if any.
+type FeedEntry struct {
+	ID       uint64
+	Time     time.Time
+	Kind     string
+	Message  string
+	Location *image.Point `json:",omitempty"`
+}
+
+// Achievement describes something players can earn by reaching Threshold in some statistic. It is sent as an event when a player
+// earned it, and listed by the server together with the number of Players who earned it. Progress is the value of the statistic
+// for the player the achievement is sent to, Earned is the time at which they earned it, if they did.
+type Achievement struct {
+	ID          string
+	Name        string
+	Description string
+	Threshold   uint64
+	Progress    uint64     `json:",omitempty"`
+	Earned      *time.Time `json:",omitempty"`
+	Players     int        `json:",omitempty"`
+}
+
+// Profile contains the lifetime statistics of a player. Playtime is in seconds.
+type Profile struct {
+	Name              string
+	Bot               bool `json:",omitempty"`
+	Score             uint
+	BestStreak        uint
+	CellsUncovered    uint64
+	Booms             uint64
+	FlagsPlaced       uint64
+	FlagsCorrect      uint64
+	DistanceTravelled uint64
+	MaxDistance       uint64
+	Playtime          float64
+	LastSeen          time.Time
+	Achievements      int
+}
+
+// Reasons for score changes
+const (
+	ReasonUncover  = "uncover"
+	ReasonHint     = "hint"
+	ReasonBoom     = "boom"
+	ReasonRunEnded = "run-ended"
+)
+
+// ScoreChange describes a change of the player's score by Delta points to Score, for the given reason
+type ScoreChange struct {
+	Delta  int
+	Score  uint
+	Reason string
+}
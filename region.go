@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/farhaven/sweeper/protocol"
+)
+
+// The world is divided into square regions of this size, each with its own leaderboard
+const _regionSize = 100
+
+// Number of entries in the leaderboard of a region
+const _numRegionScores = 10
+
+// regionOf returns the coordinates of the region that contains pt
+func regionOf(pt image.Point) image.Point {
+	div := func(a int) int {
+		if a < 0 {
+			return (a+1)/_regionSize - 1
+		}
+		return a / _regionSize
+	}
+	return image.Pt(div(pt.X), div(pt.Y))
+}
+
+// regionName returns the name of the region at the given region coordinates as shown to players
+func regionName(region image.Point) string {
+	ns, ew := "N", "E"
+	if region.Y >= 0 {
+		ns = "S"
+	}
+	if region.X < 0 {
+		ew = "W"
+	}
+	return fmt.Sprintf("%s%d %s%d", ns, abs(region.Y), ew, abs(region.X))
+}
+
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+// regionBoard returns the leaderboard of the given region, creating it if necessary
+func (s *Server) regionBoard(region image.Point) *Leaderboard {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Regions == nil {
+		s.Regions = make(map[image.Point]*Leaderboard)
+	}
+	l, ok := s.Regions[region]
+	if !ok {
+		l = NewLeaderboard(PeriodNone)
+		s.Regions[region] = l
+	}
+	return l
+}
+
+// addRegionPoints credits the player with the given ID with points earned at pt
+func (s *Server) addRegionPoints(pt image.Point, id string, points uint) {
+	if points == 0 {
+		return
+	}
+	s.regionBoard(regionOf(pt)).Add(id, points)
+}
+
+// GetRegionScores returns the name and the leaderboard of the region that contains the center of viewport
+func (s *Server) GetRegionScores(viewport image.Rectangle) (string, []protocol.HighscoreEntry) {
+	region := regionOf(viewport.Min.Add(viewport.Size().Div(2)))
+
+	s.mu.RLock()
+	l := s.Regions[region]
+	s.mu.RUnlock()
+
+	if l == nil {
+		return regionName(region), []protocol.HighscoreEntry{}
+	}
+	return regionName(region), s.highscoreEntries(l.Top(_numRegionScores))
+}
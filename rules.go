@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const _defaultRules = "classic"
+
+// Rules decide how a mine field is scored and how players are penalized. Each mine field uses one set of rules, selected by name.
+type Rules interface {
+	// CellPoints returns the number of points for revealing a field with the given number of neighboring mines
+	CellPoints(mines int) uint
+	// BoomPenalty returns the number of points a player with the given score loses for triggering a mine. forced is set if the
+	// player had no provably safe alternative.
+	BoomPenalty(score uint, forced bool) uint
+	// Lives returns the number of lives a player has per run. Once all lives are lost, the run ends and the score is reset.
+	Lives() uint
+	// LifeCost returns the number of lives a player loses for triggering a mine
+	LifeCost(forced bool) uint
+	// Cooldown returns for how long a player can't uncover or mark fields after triggering a mine
+	Cooldown(forced bool) time.Duration
+	// FloodFillRadius returns the maximum distance from the uncovered field up to which empty fields are revealed
+	FloodFillRadius() float64
+	// AllowMark returns whether a field may be marked. revealed is set if the field has been uncovered or triggered.
+	AllowMark(revealed bool) bool
+	// Respawn returns the viewport of a player after triggering a mine
+	Respawn(viewport image.Rectangle) image.Rectangle
+	// StreakMultiplier returns the factor by which points are multiplied for a player with the given number of consecutive safe
+	// uncovers
+	StreakMultiplier(streak uint) float64
+}
+
+var _rules = map[string]Rules{
+	"classic": ClassicRules{},
+	"cells":   CellRules{},
+}
+
+// RulesByName returns the rules registered under name
+func RulesByName(name string) (Rules, error) {
+	r, ok := _rules[name]
+	if !ok {
+		names := make([]string, 0, len(_rules))
+		for n := range _rules {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown rules %q, valid rules are %v", name, names)
+	}
+	return r, nil
+}
+
+// spawnViewport returns a viewport at a random location near the origin
+func spawnViewport() image.Rectangle {
+	x, y := int(rand.NormFloat64()*100), int(rand.NormFloat64()*100)
+	return image.Rect(-_viewPortWidth/2+x, -_viewPortHeight/2+y, _viewPortWidth/2+x, _viewPortHeight/2+y)
+}
+
+// ClassicRules are the original sweeper rules: revealed fields are worth their number, so empty fields are worth nothing.
+// Triggering a mine never costs points. It costs one of three lives and a cooldown of ten seconds, but forced guesses cost no life
+// and only a cooldown of three seconds. Players stay where they are. Every 10 consecutive safe uncovers increase the multiplier
+// by 0.1, up to 3.
+type ClassicRules struct{}
+
+func (ClassicRules) CellPoints(mines int) uint {
+	return uint(mines)
+}
+
+func (ClassicRules) BoomPenalty(score uint, forced bool) uint {
+	return 0
+}
+
+func (ClassicRules) Lives() uint {
+	return 3
+}
+
+func (ClassicRules) LifeCost(forced bool) uint {
+	if forced {
+		return 0
+	}
+	return 1
+}
+
+func (ClassicRules) Cooldown(forced bool) time.Duration {
+	if forced {
+		return 3 * time.Second
+	}
+	return 10 * time.Second
+}
+
+func (ClassicRules) FloodFillRadius() float64 {
+	return 30
+}
+
+func (ClassicRules) AllowMark(revealed bool) bool {
+	return true
+}
+
+func (ClassicRules) Respawn(viewport image.Rectangle) image.Rectangle {
+	return viewport
+}
+
+func (ClassicRules) StreakMultiplier(streak uint) float64 {
+	return math.Min(1+float64(streak/10)/10, 3)
+}
+
+// CellRules award one point for each revealed field. There is only one life per run, so triggering a mine ends the run unless it
+// was a forced guess, which costs half of the score instead. Afterwards, the player is moved to a random location near the
+// origin. Revealed fields can't be marked.
+type CellRules struct{}
+
+func (CellRules) CellPoints(mines int) uint {
+	return 1
+}
+
+func (CellRules) BoomPenalty(score uint, forced bool) uint {
+	if forced {
+		return score - score/2
+	}
+	return 0
+}
+
+func (CellRules) Lives() uint {
+	return 1
+}
+
+func (CellRules) LifeCost(forced bool) uint {
+	if forced {
+		return 0
+	}
+	return 1
+}
+
+func (CellRules) Cooldown(forced bool) time.Duration {
+	return 5 * time.Second
+}
+
+func (CellRules) FloodFillRadius() float64 {
+	return 30
+}
+
+func (CellRules) AllowMark(revealed bool) bool {
+	return !revealed
+}
+
+func (CellRules) Respawn(viewport image.Rectangle) image.Rectangle {
+	return spawnViewport()
+}
+
+func (CellRules) StreakMultiplier(streak uint) float64 {
+	return ClassicRules{}.StreakMultiplier(streak)
+}
@@ -3,24 +3,27 @@ package main
 import (
 	"encoding/gob"
 	"fmt"
+	"image"
+	"image/png"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
-	"sort"
+	"strconv"
 	"sync"
+	"time"
 
-	"github.com/google/uuid"
+	"github.com/farhaven/sweeper/protocol"
+	"golang.org/x/time/rate"
 )
 
 const _numHighscores = 20
 const _anonName = "Etaoin Shrdlu"
+const _maxContributionSize = 100
 
-// HighscoreEntry is an entry in the highscores table
-type HighscoreEntry struct {
-	Name  string
-	Score uint
-}
+// Largest absolute coordinate of a contribution map. Far enough out that nobody gets there, small enough that the pixel
+// coordinates of the map can't overflow.
+const _maxContributionCoordinate = 1 << 28
 
 type Server struct {
 	mu sync.RWMutex
@@ -30,9 +33,52 @@ type Server struct {
 
 	// trigger channels for updating currently connected players
 	updateChannels map[chan bool]bool
+	// event channels of currently connected players
+	eventChannels map[chan protocol.Event]*Player
+	// game events published by the mine field and the players
+	bus *Bus
+
+	// whether players may request mine probabilities for their viewport
+	allowTraining bool
+	// number of points a hint costs
+	hintCost uint
+	// issues and verifies the session tokens that identify players
+	sessions *Sessions
+	// pending link codes by code, and the limiters for requests that check passwords or link codes
+	linkCodes     map[string]linkCode
+	addressLimit  *keyedLimiter
+	usernameLimit *keyedLimiter
+	// achievements players can earn
+	achievements Achievements
+	// decides which names players may choose
+	moderation Moderation
+	// IDs of the players who flagged a field, by field, for fields that aren't known to be mines or safe yet
+	pendingFlags map[image.Point]map[string]bool
+	// counters for changes that affect the leaderboards, see leaderboardsVersion
+	namesVersion uint64
+	runsVersion  uint64
 
 	// currently active Players, or Players that have not been gone for too long
 	Players map[string]*Player
+	// registered accounts by lower case username
+	Accounts map[string]*Account
+	// IDs of guest players that were merged into another player, with the time of the merge. Their session tokens are rejected.
+	Merged map[string]time.Time
+	// names reported by players, oldest first
+	Reports []NameReport
+
+	// best finished runs
+	Runs []RunEntry
+	// leaderboards by name, see the Board* constants, and leaderboards of the regions of the world by region coordinates
+	Leaderboards map[string]*Leaderboard
+	Regions      map[image.Point]*Leaderboard
+
+	// feed of notable events, oldest first, and the ID of the most recent entry
+	Feed       []protocol.FeedEntry
+	NextFeedID uint64
+	// current leader of the highscores and the longest streak ever, used to detect feed-worthy changes
+	Leader       string
+	RecordStreak uint
 }
 
 func NewServer(m *MineField, persistencePath string) (*Server, error) {
@@ -40,12 +86,22 @@ func NewServer(m *MineField, persistencePath string) (*Server, error) {
 		m:               m,
 		persistencePath: persistencePath,
 		updateChannels:  make(map[chan bool]bool),
+		eventChannels:   make(map[chan protocol.Event]*Player),
 		Players:         make(map[string]*Player),
+		linkCodes:       make(map[string]linkCode),
+		addressLimit:    newKeyedLimiter(_addressRequestRate, _addressRequestBurst),
+		usernameLimit:   newKeyedLimiter(_usernameRequestRate, _usernameRequestBurst),
+		hintCost:        _defaultHintCost,
+		achievements:    _defaultAchievements,
+		bus:             NewBus(),
 	}
+	s.subscribeDefaults()
+	m.setBus(s.bus)
 
 	fh, err := os.Open(persistencePath)
 	if err != nil {
 		log.Println("can't load server state, using fresh server:", err)
+		s.initLeaderboards()
 		return s, nil
 	}
 	defer fh.Close()
@@ -59,48 +115,80 @@ func NewServer(m *MineField, persistencePath string) (*Server, error) {
 	// Initialize dynamic components of the server
 	for _, p := range s.Players {
 		p.setServer(s)
+
+		// Players stored before lives were introduced start with a fresh set
+		if p.Lives == 0 {
+			p.Lives = m.Rules().Lives()
+		}
+
+		// Players stored before names were generated get one now
+		if p.Name == "" {
+			p.Name = s.uniqueName(p.Id)
+		}
+
+		for pt := range p.Stats.FlaggedCells {
+			if !p.Stats.ConfirmedFlags[pt] {
+				s.addPendingFlag(pt, p.Id)
+			}
+		}
 	}
 
+	s.initLeaderboards()
+
 	log.Println("loaded server state, players:", s.Players)
 
 	return s, nil
 }
 
-func (s *Server) GetHighscores() []HighscoreEntry {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	scores := make([]HighscoreEntry, 0)
-	for _, p := range s.Players {
-		entry := HighscoreEntry{
-			Name:  _anonName,
-			Score: p.getScore(),
-		}
-		if p.Name != "" {
-			entry.Name = p.Name
-		}
-		scores = append(scores, entry)
-	}
+func (s *Server) GetHighscores() []protocol.HighscoreEntry {
+	return s.GetLeaderboard(BoardScore)
+}
 
-	sort.Slice(scores, func(i, j int) bool {
-		if scores[i].Score == scores[j].Score {
-			return scores[i].Name < scores[j].Name
-		}
-		return scores[i].Score > scores[j].Score
-	})
+// GetBestStreaks returns the players with the longest streaks of safe uncovers
+func (s *Server) GetBestStreaks() []protocol.HighscoreEntry {
+	return s.GetLeaderboard(BoardStreak)
+}
 
-	if len(scores) > _numHighscores {
-		scores = scores[:_numHighscores]
-	}
-	return scores
+// serverState holds the persisted fields of a server. Gob matches fields by name, so it is decoded directly into a Server. It has
+// to list all exported fields of Server.
+type serverState struct {
+	Players      map[string]*Player
+	Accounts     map[string]*Account
+	Merged       map[string]time.Time
+	Reports      []NameReport
+	Runs         []RunEntry
+	Leaderboards map[string]*Leaderboard
+	Regions      map[image.Point]*Leaderboard
+	Feed         []protocol.FeedEntry
+	NextFeedID   uint64
+	Leader       string
+	RecordStreak uint
 }
 
+// Persist saves the server state. Players are changed while only their own lock is held, so copies of them are saved.
 func (s *Server) Persist() error {
 	log.Println("persisting player list")
 
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	state := serverState{
+		Players:      make(map[string]*Player, len(s.Players)),
+		Accounts:     s.Accounts,
+		Merged:       s.Merged,
+		Reports:      s.Reports,
+		Runs:         s.Runs,
+		Leaderboards: s.Leaderboards,
+		Regions:      s.Regions,
+		Feed:         s.Feed,
+		NextFeedID:   s.NextFeedID,
+		Leader:       s.Leader,
+		RecordStreak: s.RecordStreak,
+	}
+	for id, p := range s.Players {
+		state.Players[id] = p.persistentCopy()
+	}
+
 	fh, err := ioutil.TempFile(".", s.persistencePath)
 	if err != nil {
 		return err
@@ -108,7 +196,7 @@ func (s *Server) Persist() error {
 	defer fh.Close()
 
 	encoder := gob.NewEncoder(fh)
-	err = encoder.Encode(s)
+	err = encoder.Encode(state)
 	if err != nil {
 		return err
 	}
@@ -137,6 +225,46 @@ func (s *Server) AddPlayer(id string) *Player {
 	return s.Players[id]
 }
 
+// GetPlayer returns the player with the given ID, or nil if there is none
+func (s *Server) GetPlayer(id string) *Player {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.Players[id]
+}
+
+// AddBot returns the player for the given bot, creating it if necessary. The player is flagged as a bot and named after it. Bot
+// names go through the same moderation as the names of players, and a bot can't take the name of another player.
+func (s *Server) AddBot(bot Bot) (*Player, error) {
+	name, err := s.moderation.Check(bot.Name)
+	if err != nil {
+		return nil, fmt.Errorf("bot name %q: %w", bot.Name, err)
+	}
+
+	id := bot.PlayerID()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.nameTaken(name, id) {
+		return nil, fmt.Errorf("bot name %q: %w", bot.Name, errNameTaken)
+	}
+
+	p, ok := s.Players[id]
+	if !ok {
+		p = NewPlayer(s, id)
+		s.Players[id] = p
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.IsBot = true
+	p.Name = name
+
+	return p, nil
+}
+
 func (s *Server) AddUpdateChannel(ch chan bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -164,8 +292,51 @@ func (s *Server) TriggerGlobalUpdate() {
 }
 
 func (s *Server) wsHandler(w http.ResponseWriter, r *http.Request) {
+	// Bots authenticate with a token instead of a cookie
+	bot, isBot, err := botFromAuthorization(r.Header.Get("Authorization"))
+	if err != nil {
+		log.Println("denying bot connection:", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintf(w, "Denied.\n")
+		return
+	}
+
+	var botPlayer *Player
+	if isBot {
+		botPlayer, err = s.AddBot(bot)
+		if err != nil {
+			log.Println("denying bot connection:", err)
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, "Denied: %s\n", err)
+			return
+		}
+	}
+
+	// Players need a valid session. Tokens that are due for rotation are replaced in the handshake response.
+	var (
+		playerID string
+		header   http.Header
+	)
+	if !isBot {
+		var rotate bool
+		playerID, rotate, err = s.sessionFromRequest(r)
+		if err == nil && isBotID(playerID) {
+			err = errInvalidToken
+		}
+		if err != nil {
+			log.Println("denying player connection:", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(w, "Denied.\n")
+			return
+		}
+		if rotate {
+			header = http.Header{}
+			header.Add("Set-Cookie", s.sessions.Cookie(r, playerID).String())
+		}
+	}
+
 	// - upgrade websocket
-	conn, err := websocketUpgrader.Upgrade(w, r, nil)
+	conn, err := websocketUpgrader.Upgrade(w, r, header)
 	if err != nil {
 		log.Printf("Can't upgrade websocket connection: %s", err)
 		r.Body.Close()
@@ -173,17 +344,57 @@ func (s *Server) wsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close()
 
-	var playerID string
-	idCookie, err := r.Cookie("sweeperID")
-	if err != nil {
-		log.Println("No sweeper ID cookie supplied, using a new randomly generated ID:", err)
-		playerID = uuid.New().String()
+	var (
+		p     *Player
+		limit *rate.Limiter
+	)
+
+	if isBot {
+		p = botPlayer
+		limit = bot.Limiter()
 	} else {
-		playerID = idCookie.Value
+		p = s.AddPlayer(playerID)
+		p.setHasSession()
+		limit = rate.NewLimiter(_playerRequestRate, _playerRequestBurst)
 	}
 
-	p := s.AddPlayer(playerID)
 	log.Println("running loop for player", p)
-	p.Loop(conn)
+	p.Loop(conn, limit)
 	log.Println("player", p, "disconnected")
 }
+
+// contributionHandler renders a PNG image of the area given by the query parameters x, y, w and h in which each uncovered field is
+// colored by the player who uncovered it.
+func (s *Server) contributionHandler(w http.ResponseWriter, r *http.Request) {
+	var params [4]int
+	for idx, name := range []string{"x", "y", "w", "h"} {
+		val, err := strconv.Atoi(r.URL.Query().Get(name))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "invalid parameter %s: %s", name, err)
+			return
+		}
+		params[idx] = val
+	}
+
+	x, y, width, height := params[0], params[1], params[2], params[3]
+	if width <= 0 || height <= 0 || width > _maxContributionSize || height > _maxContributionSize {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "width and height must be between 1 and %d", _maxContributionSize)
+		return
+	}
+	if x < -_maxContributionCoordinate || x > _maxContributionCoordinate ||
+		y < -_maxContributionCoordinate || y > _maxContributionCoordinate {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "x and y must be between %d and %d", -_maxContributionCoordinate, _maxContributionCoordinate)
+		return
+	}
+
+	img := s.m.RenderContributionImage(image.Rect(x, y, x+width, y+height), OwnerColor)
+
+	w.Header().Set("Content-Type", "image/png")
+	err := png.Encode(w, img)
+	if err != nil {
+		log.Println("can't encode contribution image:", err)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Name of the cookie that carries the session token
+const _sessionCookie = "sweeperID"
+
+// Size of the server secret used to sign session tokens, in bytes
+const _sessionSecretSize = 32
+
+// Session tokens are valid for _sessionLifetime after they were issued. Tokens older than _sessionRotation are replaced by fresh
+// ones when the player loads the page or connects.
+const _sessionLifetime = 30 * 24 * time.Hour
+const _sessionRotation = 24 * time.Hour
+
+var errInvalidToken = errors.New("invalid session token")
+var errExpiredToken = errors.New("expired session token")
+var errNoSession = errors.New("no session cookie")
+var errMergedPlayer = errors.New("player was merged into another player")
+
+// Sessions issues and verifies session tokens. A token contains the ID of the player and the time at which it was issued, signed
+// with an HMAC-SHA256 using the server secret. Tokens can't be forged without the secret, so a player ID alone isn't enough to act
+// on behalf of a player.
+type Sessions struct {
+	secret []byte
+}
+
+// sessionPayload is the signed part of a session token
+type sessionPayload struct {
+	ID     string
+	Issued int64
+}
+
+// NewSessionsFromFile reads the server secret from path. If there is no such file, a new random secret is generated and stored
+// there, readable only by the owner.
+func NewSessionsFromFile(path string) (*Sessions, error) {
+	secret, err := ioutil.ReadFile(path)
+	if err == nil {
+		if len(secret) < _sessionSecretSize {
+			return nil, fmt.Errorf("session secret in %s is too short", path)
+		}
+		return &Sessions{secret: secret}, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	log.Println("generating new session secret in", path)
+	secret = make([]byte, _sessionSecretSize)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(path, secret, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sessions{secret: secret}, nil
+}
+
+func (s *Sessions) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Issue returns a new session token for the player with the given ID
+func (s *Sessions) Issue(id string) string {
+	data, err := json.Marshal(sessionPayload{ID: id, Issued: time.Now().Unix()})
+	if err != nil {
+		// Can't happen, the payload only contains a string and a number
+		panic(err)
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + s.sign(payload)
+}
+
+// Verify checks the signature and age of token. It returns the ID of the player and the time at which the token was issued.
+func (s *Sessions) Verify(token string) (string, time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", time.Time{}, errInvalidToken
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(s.sign(parts[0]))) {
+		return "", time.Time{}, errInvalidToken
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", time.Time{}, errInvalidToken
+	}
+	var payload sessionPayload
+	err = json.Unmarshal(data, &payload)
+	if err != nil || payload.ID == "" {
+		return "", time.Time{}, errInvalidToken
+	}
+
+	issued := time.Unix(payload.Issued, 0)
+	if time.Since(issued) > _sessionLifetime {
+		return "", time.Time{}, errExpiredToken
+	}
+
+	return payload.ID, issued, nil
+}
+
+// Cookie returns a session cookie for the player with the given ID. The cookie is marked as secure if r was made over HTTPS.
+func (s *Sessions) Cookie(r *http.Request, id string) *http.Cookie {
+	return &http.Cookie{
+		Name:     _sessionCookie,
+		Value:    s.Issue(id),
+		Path:     "/",
+		MaxAge:   int(_sessionLifetime.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+	}
+}
+
+// verifySession verifies token like Sessions.Verify. Tokens of players that were merged into another player are rejected, since
+// they would otherwise bring back the merged player without its score.
+func (s *Server) verifySession(token string) (string, time.Time, error) {
+	id, issued, err := s.sessions.Verify(token)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	s.mu.RLock()
+	_, merged := s.Merged[id]
+	s.mu.RUnlock()
+	if merged {
+		return "", time.Time{}, errMergedPlayer
+	}
+
+	return id, issued, nil
+}
+
+// sessionFromRequest returns the ID of the player identified by the session cookie of r. The second return value is true if the
+// cookie should be replaced by a fresh one, because it is about to expire or because it is a legacy cookie.
+//
+// Legacy cookies contain the plain ID of a player. They are accepted once for existing players that aren't admins, so that
+// players keep their score when they get their first signed token. Admins have to get a token with -issue-token.
+func (s *Server) sessionFromRequest(r *http.Request) (string, bool, error) {
+	cookie, err := r.Cookie(_sessionCookie)
+	if err != nil {
+		return "", false, errNoSession
+	}
+
+	id, issued, err := s.verifySession(cookie.Value)
+	if err == nil {
+		return id, time.Since(issued) > _sessionRotation, nil
+	}
+
+	legacyID := cookie.Value
+	if _, uuidErr := uuid.Parse(legacyID); uuidErr == nil {
+		p := s.GetPlayer(legacyID)
+		if p != nil && !p.hasSession() && !isAdminUser(legacyID) {
+			log.Println("migrating legacy cookie of player", legacyID)
+			return legacyID, true, nil
+		}
+	}
+
+	return "", false, err
+}
+
+// setSessionCookie issues a fresh session token for the player with the given ID and sets it as a cookie on w. From then on,
+// legacy cookies aren't accepted for the player anymore.
+func (s *Server) setSessionCookie(w http.ResponseWriter, r *http.Request, id string) {
+	http.SetCookie(w, s.sessions.Cookie(r, id))
+	if p := s.GetPlayer(id); p != nil && !p.hasSession() {
+		p.setHasSession()
+		err := s.Persist()
+		if err != nil {
+			log.Println("can't persist player list:", err)
+		}
+	}
+}
+
+// hasSession returns true if the player was ever issued a session token
+func (p *Player) hasSession() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.HasSession
+}
+
+func (p *Player) setHasSession() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.HasSession = true
+}
+
+// playerFromRequest returns the player identified by the session cookie of r, or nil if there is no valid session or no such
+// player
+func (s *Server) playerFromRequest(r *http.Request) *Player {
+	id, _, err := s.sessionFromRequest(r)
+	if err != nil {
+		return nil
+	}
+	return s.GetPlayer(id)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// newTestServer returns a server with a fresh mine field that persists its state in a temporary directory, which is the working
+// directory until the test ends
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "sweeper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	})
+
+	m, err := NewMineField(4, "minefield.gob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServer(m, "server.gob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.sessions = &Sessions{secret: bytes.Repeat([]byte{1}, _sessionSecretSize)}
+
+	return s
+}
+
+// issueAt returns a session token for the player with the given ID as if it had been issued at the given time
+func issueAt(s *Sessions, id string, issued time.Time) string {
+	data, _ := json.Marshal(sessionPayload{ID: id, Issued: issued.Unix()})
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + s.sign(payload)
+}
+
+func TestSessionsVerify(t *testing.T) {
+	sessions := &Sessions{secret: bytes.Repeat([]byte{1}, _sessionSecretSize)}
+	other := &Sessions{secret: bytes.Repeat([]byte{2}, _sessionSecretSize)}
+
+	token := sessions.Issue("player")
+	tampered := issueAt(sessions, "player", time.Now())
+	tampered = tampered[:len(tampered)-1] + "A"
+	if tampered == token {
+		tampered = tampered[:len(tampered)-1] + "B"
+	}
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"valid", token, nil},
+		{"due for rotation", issueAt(sessions, "player", time.Now().Add(-2*_sessionRotation)), nil},
+		{"wrong key", other.Issue("player"), errInvalidToken},
+		{"tampered", tampered, errInvalidToken},
+		{"expired", issueAt(sessions, "player", time.Now().Add(-_sessionLifetime-time.Hour)), errExpiredToken},
+		{"plain ID", "player", errInvalidToken},
+		{"empty", "", errInvalidToken},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			id, _, err := sessions.Verify(tc.token)
+			if err != tc.err {
+				t.Fatalf("got error %v, want %v", err, tc.err)
+			}
+			if err == nil && id != "player" {
+				t.Errorf("got ID %q, want %q", id, "player")
+			}
+		})
+	}
+}
+
+func TestLegacyCookieMigration(t *testing.T) {
+	s := newTestServer(t)
+
+	id := uuid.New().String()
+	s.AddPlayer(id)
+
+	request := func(cookie string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(&http.Cookie{Name: _sessionCookie, Value: cookie})
+		return r
+	}
+
+	// The first request with the plain ID gets a signed token
+	r := request(id)
+	got, rotate, err := s.sessionFromRequest(r)
+	if err != nil || got != id || !rotate {
+		t.Fatalf("got %q, %v, %v for legacy cookie, want %q, true, nil", got, rotate, err, id)
+	}
+	w := httptest.NewRecorder()
+	s.setSessionCookie(w, r, id)
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies, want 1", len(cookies))
+	}
+	got, rotate, err = s.sessionFromRequest(request(cookies[0].Value))
+	if err != nil || got != id || rotate {
+		t.Errorf("got %q, %v, %v for new token, want %q, false, nil", got, rotate, err, id)
+	}
+
+	// After that, the plain ID isn't accepted anymore
+	_, _, err = s.sessionFromRequest(request(id))
+	if err == nil {
+		t.Error("legacy cookie was accepted twice")
+	}
+
+	// Unknown players can't be migrated
+	_, _, err = s.sessionFromRequest(request(uuid.New().String()))
+	if err == nil {
+		t.Error("legacy cookie of an unknown player was accepted")
+	}
+}
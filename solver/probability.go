@@ -0,0 +1,194 @@
+package solver
+
+import (
+	"image"
+	"math"
+)
+
+// Components with more fields than this are not enumerated exactly, since the number of configurations grows exponentially
+const _maxEnumerate = 18
+
+// Probabilities returns the probability that a mine is in each covered field of b that borders on an uncovered number (the
+// "frontier"). density is the a-priori probability of a field containing a mine and is used to weigh configurations with
+// different numbers of mines against each other.
+//
+// Fields that Solve can determine get a probability of exactly 0 or 1. The remaining frontier is split into independent groups of
+// fields that share constraints. Each group is enumerated exactly if it is small enough, larger groups are approximated by the
+// average mine density of their constraints.
+func Probabilities(b Board, density float64) map[image.Point]float64 {
+	res := make(map[image.Point]float64)
+
+	solved := Solve(b)
+	for _, pt := range solved.Safe {
+		res[pt] = 0
+	}
+
+	// Treat deduced mines like known ones, without modifying the caller's board
+	mines := make(map[image.Point]bool)
+	for pt := range b.Mines {
+		mines[pt] = true
+	}
+	for _, pt := range solved.Mines {
+		res[pt] = 1
+		mines[pt] = true
+	}
+	b.Mines = mines
+
+	// Constraints on the fields that Solve couldn't determine
+	var constraints []constraint
+	for _, c := range b.constraints() {
+		for _, pt := range solved.Safe {
+			delete(c.Fields, pt)
+		}
+		if len(c.Fields) != 0 {
+			constraints = append(constraints, c)
+		}
+	}
+
+	for _, group := range groupConstraints(constraints) {
+		var probs map[image.Point]float64
+		if fields := groupFields(group); len(fields) <= _maxEnumerate {
+			probs = enumerate(fields, group, density)
+		} else {
+			probs = approximate(group)
+		}
+		for pt, p := range probs {
+			res[pt] = p
+		}
+	}
+
+	return res
+}
+
+// groupConstraints splits constraints into groups that don't share any fields
+func groupConstraints(constraints []constraint) [][]constraint {
+	// Union-find over constraint indices
+	parent := make([]int, len(constraints))
+	for idx := range parent {
+		parent[idx] = idx
+	}
+	var find func(int) int
+	find = func(idx int) int {
+		if parent[idx] != idx {
+			parent[idx] = find(parent[idx])
+		}
+		return parent[idx]
+	}
+
+	owner := make(map[image.Point]int)
+	for idx, c := range constraints {
+		for pt := range c.Fields {
+			if other, ok := owner[pt]; ok {
+				parent[find(idx)] = find(other)
+			} else {
+				owner[pt] = idx
+			}
+		}
+	}
+
+	groups := make(map[int][]constraint)
+	for idx, c := range constraints {
+		root := find(idx)
+		groups[root] = append(groups[root], c)
+	}
+
+	res := make([][]constraint, 0, len(groups))
+	for _, g := range groups {
+		res = append(res, g)
+	}
+	return res
+}
+
+func groupFields(group []constraint) []image.Point {
+	set := make(map[image.Point]bool)
+	for _, c := range group {
+		for pt := range c.Fields {
+			set[pt] = true
+		}
+	}
+	return sortedPoints(set)
+}
+
+// enumerate computes exact mine probabilities for fields by weighing every assignment of mines that satisfies all constraints.
+func enumerate(fields []image.Point, constraints []constraint, density float64) map[image.Point]float64 {
+	// Relative weight of a configuration with one more mine
+	ratio := density / (1 - density)
+
+	assignment := make(map[image.Point]bool)
+	mineWeights := make([]float64, len(fields))
+	var total float64
+
+	// consistent checks whether the current partial assignment can still satisfy all constraints
+	consistent := func() bool {
+		for _, c := range constraints {
+			mines, open := 0, 0
+			for pt := range c.Fields {
+				isMine, assigned := assignment[pt]
+				if !assigned {
+					open++
+				} else if isMine {
+					mines++
+				}
+			}
+			if mines > c.Mines || mines+open < c.Mines {
+				return false
+			}
+		}
+		return true
+	}
+
+	var walk func(idx, mines int)
+	walk = func(idx, mines int) {
+		if !consistent() {
+			return
+		}
+		if idx == len(fields) {
+			w := math.Pow(ratio, float64(mines))
+			total += w
+			for i, pt := range fields {
+				if assignment[pt] {
+					mineWeights[i] += w
+				}
+			}
+			return
+		}
+
+		pt := fields[idx]
+		assignment[pt] = false
+		walk(idx+1, mines)
+		assignment[pt] = true
+		walk(idx+1, mines+1)
+		delete(assignment, pt)
+	}
+	walk(0, 0)
+
+	res := make(map[image.Point]float64)
+	if total == 0 {
+		// Contradictory constraints, for example because of a wrong flag
+		return res
+	}
+	for i, pt := range fields {
+		res[pt] = mineWeights[i] / total
+	}
+	return res
+}
+
+// approximate estimates mine probabilities for fields by averaging the mine density of the constraints they are part of
+func approximate(constraints []constraint) map[image.Point]float64 {
+	sums := make(map[image.Point]float64)
+	counts := make(map[image.Point]int)
+
+	for _, c := range constraints {
+		p := float64(c.Mines) / float64(len(c.Fields))
+		for pt := range c.Fields {
+			sums[pt] += p
+			counts[pt]++
+		}
+	}
+
+	res := make(map[image.Point]float64)
+	for pt, sum := range sums {
+		res[pt] = sum / float64(counts[pt])
+	}
+	return res
+}
@@ -0,0 +1,156 @@
+package solver
+
+import (
+	"image"
+	"math"
+	"strings"
+	"testing"
+)
+
+// bruteForce computes the mine probabilities of the covered fields of b that border on a number by trying every assignment
+func bruteForce(b Board, density float64) map[image.Point]float64 {
+	constraints := b.constraints()
+	fields := groupFields(constraints)
+	ratio := density / (1 - density)
+
+	weights := make([]float64, len(fields))
+	var total float64
+	for bits := 0; bits < 1<<uint(len(fields)); bits++ {
+		mines := make(map[image.Point]bool)
+		for i, pt := range fields {
+			if bits&(1<<uint(i)) != 0 {
+				mines[pt] = true
+			}
+		}
+
+		ok := true
+		for _, c := range constraints {
+			count := 0
+			for pt := range c.Fields {
+				if mines[pt] {
+					count++
+				}
+			}
+			if count != c.Mines {
+				ok = false
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		w := math.Pow(ratio, float64(len(mines)))
+		total += w
+		for i, pt := range fields {
+			if mines[pt] {
+				weights[i] += w
+			}
+		}
+	}
+
+	res := make(map[image.Point]float64)
+	for i, pt := range fields {
+		res[pt] = weights[i] / total
+	}
+	return res
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestProbabilities(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		rows []string
+		want map[image.Point]float64
+	}{
+		{
+			name: "one of eight",
+			rows: []string{
+				"###",
+				"#1#",
+				"###",
+			},
+			want: map[image.Point]float64{
+				{0, 0}: 0.125, {1, 0}: 0.125, {2, 0}: 0.125, {0, 1}: 0.125,
+				{2, 1}: 0.125, {0, 2}: 0.125, {1, 2}: 0.125, {2, 2}: 0.125,
+			},
+		},
+		{
+			name: "solved",
+			rows: []string{
+				"0###0",
+				"01110",
+				"00000",
+			},
+			want: map[image.Point]float64{{1, 0}: 0, {2, 0}: 1, {3, 0}: 0},
+		},
+		{
+			name: "fifty-fifty",
+			rows: []string{
+				"*##*",
+				"2221",
+				"0000",
+			},
+			want: map[image.Point]float64{{1, 0}: 0.5, {2, 0}: 0.5},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Probabilities(parseBoard(tc.rows...), 0.2)
+			if len(got) != len(tc.want) {
+				t.Fatalf("got probabilities for %d fields, want %d: %v", len(got), len(tc.want), got)
+			}
+			for pt, want := range tc.want {
+				if !near(got[pt], want) {
+					t.Errorf("got probability %f for %s, want %f", got[pt], pt, want)
+				}
+			}
+		})
+	}
+}
+
+func TestProbabilitiesEnumerationCutoff(t *testing.T) {
+	const density = 0.2
+
+	// Covered fields above numbers that belong to the mines x..xx.xx..x.x.xx..x. Nothing can be deduced, so all covered fields
+	// form a single group. Enumerating it gives different probabilities than the approximation.
+	for _, tc := range []struct {
+		numbers string
+		exact   bool
+	}{
+		{"111222221112122210", true},
+		{"1112222211121222111", false},
+	} {
+		n := len(tc.numbers)
+		b := parseBoard(strings.Repeat("#", n), tc.numbers, strings.Repeat("0", n))
+
+		exact := bruteForce(b, density)
+		approximated := approximate(b.constraints())
+		want := approximated
+		if tc.exact {
+			want = exact
+		}
+
+		differ := false
+		for pt := range exact {
+			if !near(exact[pt], approximated[pt]) {
+				differ = true
+			}
+		}
+		if !differ {
+			t.Fatalf("%d fields: can't tell enumeration and approximation apart", n)
+		}
+
+		got := Probabilities(b, density)
+		if len(got) != n {
+			t.Fatalf("%d fields: got probabilities for %d fields", n, len(got))
+		}
+		for pt, p := range want {
+			if !near(got[pt], p) {
+				t.Errorf("%d fields: got probability %f for %s, want %f", n, got[pt], pt, p)
+			}
+		}
+	}
+}
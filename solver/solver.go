@@ -0,0 +1,227 @@
+// Package solver deduces provably safe fields and provably mined fields from the visible state of a part of a mine field.
+//
+// The solver only works with what players can see: uncovered numbers, triggered mines and (optionally) flags. It never needs to
+// know where the mines actually are.
+package solver
+
+import (
+	"image"
+	"sort"
+
+	"github.com/farhaven/sweeper/protocol"
+)
+
+// Board is the visible state of a bounded region of a mine field. Fields inside Bounds that are neither in Numbers nor in Mines
+// are covered.
+type Board struct {
+	Bounds image.Rectangle
+	// Uncovered fields and their number of neighboring mines
+	Numbers map[image.Point]int
+	// Fields that are known to contain a mine, for example because the mine was triggered
+	Mines map[image.Point]bool
+	// Fields that have been flagged by players. Flags may be wrong, so they are only used if TrustFlags is set.
+	Flags      map[image.Point]bool
+	TrustFlags bool
+}
+
+// NewBoard returns an empty board for the given bounds
+func NewBoard(bounds image.Rectangle) Board {
+	return Board{
+		Bounds:  bounds,
+		Numbers: make(map[image.Point]int),
+		Mines:   make(map[image.Point]bool),
+		Flags:   make(map[image.Point]bool),
+	}
+}
+
+// BoardFromViewPort returns the board that is visible in vp
+func BoardFromViewPort(vp protocol.ViewPort) Board {
+	b := NewBoard(vp.Position)
+
+	for y, row := range vp.Data {
+		for x, e := range row {
+			pt := vp.Position.Min.Add(image.Pt(x, y))
+			switch {
+			case e >= protocol.VPEZero && e <= protocol.VPEEight:
+				b.Numbers[pt] = int(e - protocol.VPEZero)
+			case e == protocol.VPEMine:
+				b.Mines[pt] = true
+			case e == protocol.VPEFlag:
+				b.Flags[pt] = true
+			}
+		}
+	}
+
+	return b
+}
+
+// IsCovered returns true if pt lies within the board and hasn't been uncovered or triggered
+func (b Board) IsCovered(pt image.Point) bool {
+	if !pt.In(b.Bounds) {
+		return false
+	}
+	_, isNumber := b.Numbers[pt]
+	return !isNumber && !b.Mines[pt]
+}
+
+func (b Board) isMine(pt image.Point) bool {
+	return b.Mines[pt] || (b.TrustFlags && b.Flags[pt])
+}
+
+// Neighbors returns the 8 points around p.
+func Neighbors(p image.Point) [8]image.Point {
+	return [8]image.Point{
+		image.Pt(p.X-1, p.Y-1), image.Pt(p.X, p.Y-1), image.Pt(p.X+1, p.Y-1),
+		image.Pt(p.X-1, p.Y), image.Pt(p.X+1, p.Y),
+		image.Pt(p.X-1, p.Y+1), image.Pt(p.X, p.Y+1), image.Pt(p.X+1, p.Y+1),
+	}
+}
+
+// Result contains the fields that were deduced to be safe or mined, in row-major order
+type Result struct {
+	Safe  []image.Point
+	Mines []image.Point
+}
+
+// constraint states that exactly Mines of the covered fields in Fields contain a mine
+type constraint struct {
+	Fields map[image.Point]bool
+	Mines  int
+}
+
+func (c constraint) isSubsetOf(other constraint) bool {
+	if len(c.Fields) > len(other.Fields) {
+		return false
+	}
+	for pt := range c.Fields {
+		if !other.Fields[pt] {
+			return false
+		}
+	}
+	return true
+}
+
+// constraints returns the constraints imposed on the covered fields of b by its uncovered numbers. Numbers whose neighborhood
+// isn't entirely inside the bounds of b are skipped, since fields outside the bounds may have been uncovered.
+func (b Board) constraints() []constraint {
+	var res []constraint
+
+	inner := b.Bounds.Inset(1)
+	for pt, count := range b.Numbers {
+		if !pt.In(inner) {
+			continue
+		}
+
+		c := constraint{
+			Fields: make(map[image.Point]bool),
+			Mines:  count,
+		}
+		for _, n := range Neighbors(pt) {
+			if b.isMine(n) {
+				c.Mines--
+			} else if b.IsCovered(n) {
+				c.Fields[n] = true
+			}
+		}
+
+		if len(c.Fields) != 0 {
+			res = append(res, c)
+		}
+	}
+
+	return res
+}
+
+// Solve returns the covered fields in b that are provably safe or provably mines. It combines single constraints (a number
+// that already sees all of its mines, or that has as many covered neighbors as mines) with subset reasoning: if the covered
+// neighbors of one number are a subset of those of another, the difference must contain the difference of their mine counts.
+func Solve(b Board) Result {
+	safe := make(map[image.Point]bool)
+	mines := make(map[image.Point]bool)
+
+	constraints := b.constraints()
+	for changed := true; changed; {
+		changed = false
+
+		// Remove fields that have been determined since the last round
+		var reduced []constraint
+		for _, c := range constraints {
+			for pt := range c.Fields {
+				if safe[pt] {
+					delete(c.Fields, pt)
+				} else if mines[pt] {
+					delete(c.Fields, pt)
+					c.Mines--
+				}
+			}
+			if len(c.Fields) != 0 {
+				reduced = append(reduced, c)
+			}
+		}
+		constraints = reduced
+
+		// Trivial constraints
+		for _, c := range constraints {
+			if c.Mines != 0 && c.Mines != len(c.Fields) {
+				continue
+			}
+			for pt := range c.Fields {
+				if c.Mines == 0 {
+					safe[pt] = true
+				} else {
+					mines[pt] = true
+				}
+				changed = true
+			}
+		}
+		if changed {
+			continue
+		}
+
+		// Subset reasoning
+		seen := len(constraints)
+		for i := 0; i < seen; i++ {
+			for j := 0; j < seen; j++ {
+				sub, super := constraints[i], constraints[j]
+				if i == j || len(sub.Fields) >= len(super.Fields) || !sub.isSubsetOf(super) {
+					continue
+				}
+
+				diff := constraint{
+					Fields: make(map[image.Point]bool),
+					Mines:  super.Mines - sub.Mines,
+				}
+				for pt := range super.Fields {
+					if !sub.Fields[pt] {
+						diff.Fields[pt] = true
+					}
+				}
+				if diff.Mines == 0 || diff.Mines == len(diff.Fields) {
+					constraints = append(constraints, diff)
+					changed = true
+				}
+			}
+		}
+	}
+
+	return Result{
+		Safe:  sortedPoints(safe),
+		Mines: sortedPoints(mines),
+	}
+}
+
+func sortedPoints(set map[image.Point]bool) []image.Point {
+	res := make([]image.Point, 0, len(set))
+	for pt := range set {
+		res = append(res, pt)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Y == res[j].Y {
+			return res[i].X < res[j].X
+		}
+		return res[i].Y < res[j].Y
+	})
+
+	return res
+}
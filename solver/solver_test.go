@@ -0,0 +1,130 @@
+package solver
+
+import (
+	"image"
+	"reflect"
+	"testing"
+)
+
+// parseBoard returns the board described by rows. '#' is a covered field, 'F' a flagged covered field, '*' a known mine and a
+// digit an uncovered field with that number. The top left field is at 0, 0.
+func parseBoard(rows ...string) Board {
+	b := NewBoard(image.Rect(0, 0, len(rows[0]), len(rows)))
+
+	for y, row := range rows {
+		for x, r := range row {
+			pt := image.Pt(x, y)
+			switch {
+			case r == 'F':
+				b.Flags[pt] = true
+			case r == '*':
+				b.Mines[pt] = true
+			case r >= '0' && r <= '8':
+				b.Numbers[pt] = int(r - '0')
+			}
+		}
+	}
+
+	return b
+}
+
+func TestSolve(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		rows       []string
+		trustFlags bool
+		safe       []image.Point
+		mines      []image.Point
+	}{
+		{
+			name: "zero",
+			rows: []string{
+				"###",
+				"#0#",
+				"###",
+			},
+			safe: []image.Point{{0, 0}, {1, 0}, {2, 0}, {0, 1}, {2, 1}, {0, 2}, {1, 2}, {2, 2}},
+		},
+		{
+			name: "all mines",
+			rows: []string{
+				"###",
+				"#8#",
+				"###",
+			},
+			mines: []image.Point{{0, 0}, {1, 0}, {2, 0}, {0, 1}, {2, 1}, {0, 2}, {1, 2}, {2, 2}},
+		},
+		{
+			name: "known mine",
+			rows: []string{
+				"*##",
+				"#1#",
+				"###",
+			},
+			safe: []image.Point{{1, 0}, {2, 0}, {0, 1}, {2, 1}, {0, 2}, {1, 2}, {2, 2}},
+		},
+		{
+			name: "untrusted flag",
+			rows: []string{
+				"F##",
+				"#1#",
+				"###",
+			},
+		},
+		{
+			name: "trusted flag",
+			rows: []string{
+				"F##",
+				"#1#",
+				"###",
+			},
+			trustFlags: true,
+			safe:       []image.Point{{1, 0}, {2, 0}, {0, 1}, {2, 1}, {0, 2}, {1, 2}, {2, 2}},
+		},
+		{
+			name: "undecidable",
+			rows: []string{
+				"###",
+				"#1#",
+				"###",
+			},
+		},
+		{
+			name: "numbers on the border are ignored",
+			rows: []string{
+				"0##",
+				"###",
+				"###",
+			},
+		},
+		{
+			// The covered neighbors of the outer numbers are subsets of those of the middle one, which leaves the corners safe
+			// and the middle field as the mine
+			name: "subset",
+			rows: []string{
+				"0###0",
+				"01110",
+				"00000",
+			},
+			safe:  []image.Point{{1, 0}, {3, 0}},
+			mines: []image.Point{{2, 0}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := parseBoard(tc.rows...)
+			b.TrustFlags = tc.trustFlags
+
+			res := Solve(b)
+			if len(res.Safe) != 0 || len(tc.safe) != 0 {
+				if !reflect.DeepEqual(res.Safe, tc.safe) {
+					t.Errorf("got safe fields %v, want %v", res.Safe, tc.safe)
+				}
+			}
+			if len(res.Mines) != 0 || len(tc.mines) != 0 {
+				if !reflect.DeepEqual(res.Mines, tc.mines) {
+					t.Errorf("got mines %v, want %v", res.Mines, tc.mines)
+				}
+			}
+		})
+	}
+}
@@ -90,10 +90,67 @@ var Admin = {
 		});
 	},
 
+	showReports: function(reports) {
+		// The rows are added directly, so that the event listeners of the buttons survive
+		let table = document.getElementById("reports");
+		table.innerHTML = "";
+
+		function button(label, handler) {
+			let btn = document.createElement("input");
+			btn.value = label;
+			btn.type = "button";
+			btn.addEventListener("click", handler);
+			return btn;
+		};
+
+		reports.forEach((report, idx) => {
+			let row = document.createElement("tr");
+			row.appendChild(Admin.tableData(idx));
+			row.appendChild(Admin.tableData(report.PlayerID, true));
+			row.appendChild(Admin.tableData(report.Name));
+			row.appendChild(Admin.tableData(report.Reporters.length));
+			row.appendChild(Admin.tableData(new Date(report.Reported).toLocaleString()));
+
+			let name = document.createElement("input");
+			name.type = "text";
+			name.placeholder = "generated";
+			let lock = document.createElement("input");
+			lock.type = "number";
+			lock.placeholder = "168";
+			for (let input of [name, lock]) {
+				let td = document.createElement("td");
+				td.appendChild(input);
+				row.appendChild(td);
+			}
+
+			let actions = document.createElement("td");
+			actions.appendChild(button("Rename", () => {
+				Admin.request({
+					Request: "force-rename",
+					Player: report.PlayerID,
+					Name: name.value,
+					LockHours: parseFloat(lock.value) || 0,
+				}).then(Admin.showReports);
+			}));
+			actions.appendChild(button("Dismiss", () => {
+				Admin.request({Request: "dismiss-report", Player: report.PlayerID}).then(Admin.showReports);
+			}));
+			row.appendChild(actions);
+
+			table.appendChild(row);
+		});
+	},
+
+	updateReports: function() {
+		console.log("updating list of reported names");
+		Admin.request({Request: "get-reports"}).then(Admin.showReports);
+	},
+
 	setup: function() {
 		console.log("admin setup called");
 		Admin.updatePlayers();
 		Admin.updateAdmins();
+		Admin.updateReports();
 	},
 };
 
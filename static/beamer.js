@@ -0,0 +1,36 @@
+var Beamer = {
+	maxFeedEntries: 15,
+	mapSize: 100,
+	// The map changes slowly and is expensive to render, so it is reloaded less often than the feed
+	mapInterval: 30000,
+	feedInterval: 5000,
+
+	updateFeed: async function() {
+		let resp = await fetch("/feed?limit=" + Beamer.maxFeedEntries);
+		let entries = await resp.json();
+
+		let list = document.createElement("ul");
+		for (let entry of entries) {
+			let item = document.createElement("li");
+			item.innerText = new Date(entry.Time).toLocaleTimeString() + ": " + entry.Message;
+			list.appendChild(item);
+		}
+		document.getElementById("feed").innerHTML = list.innerHTML;
+	},
+
+	updateMap: function() {
+		let half = Beamer.mapSize / 2;
+		let map = document.getElementById("map");
+		map.src = "/contributions.png?x=" + (-half) + "&y=" + (-half) + "&w=" + Beamer.mapSize + "&h=" + Beamer.mapSize +
+			"&t=" + Date.now();
+	},
+
+	setup: function() {
+		Beamer.updateFeed();
+		Beamer.updateMap();
+		setInterval(Beamer.updateFeed, Beamer.feedInterval);
+		setInterval(Beamer.updateMap, Beamer.mapInterval);
+	}
+};
+
+window.addEventListener("load", Beamer.setup, false);
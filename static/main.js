@@ -38,7 +38,7 @@ var Sweeper = {
 		canvas.style["margin-left"] = padding + "px";
 	},
 
-	updateHighscores: function(scores) {
+	updateHighscores: function(scores, target) {
 		let tbody = document.createElement("tbody");
 		for (idx = 0; idx < scores.length; idx++) {
 			let row = document.createElement("tr");
@@ -49,6 +49,9 @@ var Sweeper = {
 
 			let name = document.createElement("td");
 			name.innerText = scores[idx].Name;
+			if (scores[idx].Bot) {
+				name.innerText += " (bot)";
+			}
 			name.classList.add("fixed-width");
 			row.appendChild(name);
 
@@ -58,16 +61,171 @@ var Sweeper = {
 
 			tbody.appendChild(row);
 		}
-		let highscoreTable = document.getElementById("scoredata");
+		let highscoreTable = document.getElementById(target);
 		highscoreTable.innerHTML = tbody.innerHTML;
 	},
 
+	maxFeedEntries: 50,
+	leaderboardsVersion: null,
+
+	addFeedEntry: function(entry, append) {
+		let list = document.getElementById("feed");
+		let item = document.createElement("li");
+		item.innerText = new Date(entry.Time).toLocaleTimeString() + ": " + entry.Message;
+		if (append) {
+			list.appendChild(item);
+		} else {
+			list.insertBefore(item, list.firstChild);
+		}
+		while (list.children.length > Sweeper.maxFeedEntries) {
+			list.removeChild(list.lastChild);
+		}
+	},
+
+	loadFeed: async function() {
+		let resp = await fetch("feed?limit=" + Sweeper.maxFeedEntries);
+		let entries = await resp.json();
+		for (let entry of entries) {
+			Sweeper.addFeedEntry(entry, true);
+		}
+	},
+
+	accountRequest: async function(request) {
+		let resp = await fetch("account", {
+			method: "POST",
+			body: JSON.stringify(request),
+		});
+		if (!resp.ok) {
+			document.getElementById("account-error").innerText = await resp.text();
+			return null;
+		}
+		document.getElementById("account-error").innerText = "";
+		let res = await resp.json();
+		if (res.Username) {
+			document.getElementById("account-status").innerText = "Logged in as " + res.Username + ".";
+			document.getElementById("account-form").hidden = true;
+		}
+		return res;
+	},
+
+	setupAccount: function() {
+		let field = id => document.getElementById("account-" + id);
+		let credentials = kind => ({
+			Request: kind,
+			Username: field("username").value,
+			Password: field("password").value,
+			Merge: field("merge").checked,
+		});
+
+		field("register").addEventListener("click", () => {
+			Sweeper.accountRequest(credentials("register"));
+		});
+		field("login").addEventListener("click", async () => {
+			// The page is reloaded to reconnect as the player of the account
+			if (await Sweeper.accountRequest(credentials("login"))) {
+				window.location.reload();
+			}
+		});
+		field("link-code").addEventListener("click", async () => {
+			let res = await Sweeper.accountRequest({Request: "link-code"});
+			if (res) {
+				field("code").innerText = res.Code;
+			}
+		});
+		field("link").addEventListener("click", async () => {
+			let request = {
+				Request: "link",
+				Code: field("code-input").value,
+				Merge: field("merge").checked,
+			};
+			if (await Sweeper.accountRequest(request)) {
+				window.location.reload();
+			}
+		});
+
+		Sweeper.accountRequest({Request: "status"});
+	},
+
+	showEvent: function(text) {
+		let eventSpan = document.getElementById("event");
+		eventSpan.innerText = text;
+	},
+
+	handleEvent: function(event) {
+		switch (event.Event) {
+			case "boom":
+				if (!event.Boom.Own) {
+					Sweeper.showEvent("BOOM! " + event.Boom.Player + " triggered a mine nearby");
+					break;
+				}
+				var boomText = "BOOM!";
+				if (event.Boom.Forced) {
+					boomText += " Forced guess, reduced penalty.";
+				}
+				if (event.Boom.RunEnded) {
+					boomText += " That was your last life, your run is over.";
+				} else if (event.Boom.LifeLost) {
+					boomText += " You lost a life.";
+				}
+				Sweeper.showEvent(boomText);
+				break;
+			case "score":
+				if (event.Score.Delta < 0) {
+					Sweeper.showEvent("You lost " + (-event.Score.Delta) + " points (" + event.Score.Reason + ")");
+				}
+				break;
+			case "cooldown":
+				Sweeper.showEvent("Cooling down for " + Math.ceil(event.Cooldown) + " more seconds");
+				break;
+			case "hint":
+				Sweeper.showEvent(event.Hint.Message);
+				if (event.Hint.Deduced) {
+					var hintStyle = event.Hint.Mine ? "rgba(200, 0, 0, 0.5)" : "rgba(0, 160, 0, 0.5)";
+					Sweeper.drawFieldElement(event.Hint.X, event.Hint.Y, null, null, hintStyle);
+				}
+				break;
+			case "error":
+				Sweeper.showEvent("Error: " + event.Error);
+				break;
+			case "feed":
+				Sweeper.addFeedEntry(event.Feed, false);
+				break;
+			case "profile":
+				var pr = event.Profile;
+				Sweeper.showEvent(pr.Name + ": " + pr.CellsUncovered + " fields uncovered, " + pr.Booms + " booms, " +
+					pr.FlagsCorrect + " of " + pr.FlagsPlaced + " flags correct, " + pr.DistanceTravelled + " fields travelled, " +
+					Math.round(pr.Playtime / 60) + " minutes played");
+				break;
+			case "achievement":
+				Sweeper.showEvent("Achievement unlocked: " + event.Achievement.Name + " (" + event.Achievement.Description + ")");
+				break;
+			case "reported":
+				Sweeper.showEvent("Thanks, the admins will have a look at the name " + event.Reported);
+				break;
+			default:
+				console.log("unknown event", event);
+				break;
+		}
+	},
+
 	handleMessage: function(socketMessage) {
 		var message = JSON.parse(socketMessage.data);
 
+		if (message.Event) {
+			Sweeper.handleEvent(message);
+			return;
+		}
+
 		// Update position display
 		var locSpan = document.getElementById("location");
 		locSpan.innerText = message.Score + " @ " + JSON.stringify(message.ViewPort.Position);
+		locSpan.innerText += " lives " + message.Lives;
+		if (message.Cooldown > 0) {
+			locSpan.innerText += " cooling down for " + Math.ceil(message.Cooldown) + "s";
+		}
+		if (message.Streak > 0) {
+			locSpan.innerText += " streak " + message.Streak + " (x" + message.Multiplier.toFixed(1) + ")";
+		}
 
 		// Update player name
 		var playerName = document.getElementById("player-name");
@@ -105,11 +263,31 @@ var Sweeper = {
 						break;
 				}
 
+				if (message.Contribution && message.Contribution[y][x] && fillStyle == null) {
+					fillStyle = "#d8ecd8";
+				}
+
+				if (message.Probabilities && message.Probabilities[y][x] >= 0 && txt == null) {
+					fillStyle = "rgba(200, 0, 0, " + (message.Probabilities[y][x] * 0.8) + ")";
+				}
+
 				Sweeper.drawFieldElement(x, y, txt, textStyle, fillStyle);
 			}
 		}
 
-		Sweeper.updateHighscores(message.Highscores);
+		document.getElementById("region-name").innerText = "Region " + message.Region;
+		Sweeper.updateHighscores(message.RegionScores, "regiondata");
+
+		// Leaderboards are only sent when they changed. Empty ones are left out as well.
+		if (message.LeaderboardsVersion !== Sweeper.leaderboardsVersion) {
+			Sweeper.leaderboardsVersion = message.LeaderboardsVersion;
+			Sweeper.updateHighscores(message.Highscores || [], "scoredata");
+			Sweeper.updateHighscores(message.Daily || [], "dailydata");
+			Sweeper.updateHighscores(message.Weekly || [], "weeklydata");
+			Sweeper.updateHighscores(message.BestScores || [], "bestdata");
+			Sweeper.updateHighscores(message.BestStreaks || [], "streakdata");
+			Sweeper.updateHighscores(message.Runs || [], "rundata");
+		}
 	},
 
 	clearField: function() {
@@ -209,6 +387,26 @@ var Sweeper = {
 				case "ArrowDown":
 					request.Y += 1;
 					break;
+				case "c":
+					request = {
+						Kind: "toggle-contribution"
+					}
+					break;
+				case "t":
+					request = {
+						Kind: "toggle-training"
+					}
+					break;
+				case "h":
+					request = {
+						Kind: "hint"
+					}
+					break;
+				case "p":
+					request = {
+						Kind: "profile"
+					}
+					break;
 				default:
 					return;
 			}
@@ -340,33 +538,29 @@ var Sweeper = {
 		});
 
 		// Wire up side bar
-		function sidebar(showWhatsThis, showHighscores) {
-			let whatsthis = document.getElementById("whatsthis");
-			let highscores = document.getElementById("highscores");
-			whatsthis.hidden = !showWhatsThis;
-			highscores.hidden = !showHighscores;
-
-			let selectWhatsThis = document.getElementById("select-whatsthis");
-			let selectHighscores = document.getElementById("select-highscores");
-
-			if (showWhatsThis) {
-				selectWhatsThis.classList.add("pure-menu-selected");
-				selectHighscores.classList.remove("pure-menu-selected");
-			}
-			if (showHighscores) {
-				selectWhatsThis.classList.remove("pure-menu-selected");
-				selectHighscores.classList.add("pure-menu-selected");
+		let tabs = ["whatsthis", "highscores", "news", "account"];
+		function sidebar(selected) {
+			for (let tab of tabs) {
+				document.getElementById(tab).hidden = (tab != selected);
+
+				let select = document.getElementById("select-" + tab);
+				if (tab == selected) {
+					select.classList.add("pure-menu-selected");
+				} else {
+					select.classList.remove("pure-menu-selected");
+				}
 			}
 		}
 
-		document.getElementById("select-whatsthis").addEventListener("click", event => {
-			event.preventDefault();
-			sidebar(true, false);
-		});
-		document.getElementById("select-highscores").addEventListener("click", event => {
-			event.preventDefault();
-			sidebar(false, true);
-		})
+		for (let tab of tabs) {
+			document.getElementById("select-" + tab).addEventListener("click", event => {
+				event.preventDefault();
+				sidebar(tab);
+			});
+		}
+
+		Sweeper.loadFeed();
+		Sweeper.setupAccount();
 
 		// Highscore name entry
 		let playerName = document.getElementById("player-name")
@@ -377,6 +571,17 @@ var Sweeper = {
 			};
 			ws.send(JSON.stringify(request));
 		});
+
+		// Reporting names of other players
+		let reportName = document.getElementById("report-name")
+		reportName.addEventListener("change", event => {
+			let request = {
+				Kind: "report",
+				Name: reportName.value,
+			};
+			ws.send(JSON.stringify(request));
+			reportName.value = "";
+		});
 	}
 };
 
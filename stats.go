@@ -0,0 +1,218 @@
+package main
+
+import (
+	"image"
+	"math"
+	"time"
+
+	"github.com/farhaven/sweeper/solver"
+)
+
+// Size of the area around a flag that is used to prove that the flagged field contains a mine
+const _flagProofRadius = 5
+
+// PlayerStats are counters about the actions of a player, kept across runs
+type PlayerStats struct {
+	// Number of fields uncovered, including those revealed by flood fills
+	CellsUncovered uint64
+	// Number of mines triggered
+	Booms uint64
+	// Number of fields the player flagged, and the number of those that turned out to be mines
+	FlagsPlaced  uint64
+	FlagsCorrect uint64
+	// Number of safe uncovers since the last explosion
+	UncoversSinceBoom uint64
+	// Largest distance from the origin of a field the player uncovered or looked at
+	MaxDistance uint64
+	// Total distance the viewport of the player was moved
+	DistanceTravelled uint64
+	// Time the player spent connected to the server, and the last time they were seen
+	Playtime time.Duration
+	LastSeen time.Time
+	// Fields the player flagged and those on which a correct flag of the player was counted, so that cycling through the marks
+	// doesn't count twice
+	FlaggedCells   map[image.Point]bool
+	ConfirmedFlags map[image.Point]bool
+}
+
+// copy returns a copy of st that doesn't share its maps with st
+func (st PlayerStats) copy() PlayerStats {
+	copyCells := func(cells map[image.Point]bool) map[image.Point]bool {
+		res := make(map[image.Point]bool, len(cells))
+		for pt := range cells {
+			res[pt] = true
+		}
+		return res
+	}
+
+	res := st
+	res.FlaggedCells = copyCells(st.FlaggedCells)
+	res.ConfirmedFlags = copyCells(st.ConfirmedFlags)
+	return res
+}
+
+// merge adds the counters of other to st. The distance from the origin and the last time seen are the larger of both.
+func (st *PlayerStats) merge(other PlayerStats) {
+	st.CellsUncovered += other.CellsUncovered
+	st.Booms += other.Booms
+	st.FlagsPlaced += other.FlagsPlaced
+	st.FlagsCorrect += other.FlagsCorrect
+	st.UncoversSinceBoom += other.UncoversSinceBoom
+	st.DistanceTravelled += other.DistanceTravelled
+	st.Playtime += other.Playtime
+	if other.MaxDistance > st.MaxDistance {
+		st.MaxDistance = other.MaxDistance
+	}
+	if other.LastSeen.After(st.LastSeen) {
+		st.LastSeen = other.LastSeen
+	}
+	if len(other.FlaggedCells) > 0 && st.FlaggedCells == nil {
+		st.FlaggedCells = make(map[image.Point]bool)
+	}
+	for pt := range other.FlaggedCells {
+		st.FlaggedCells[pt] = true
+	}
+	if len(other.ConfirmedFlags) > 0 && st.ConfirmedFlags == nil {
+		st.ConfirmedFlags = make(map[image.Point]bool)
+	}
+	for pt := range other.ConfirmedFlags {
+		st.ConfirmedFlags[pt] = true
+	}
+}
+
+// Names of the metrics that achievements can be based on
+const (
+	MetricCellsUncovered    = "cells-uncovered"
+	MetricFlagsCorrect      = "flags-correct"
+	MetricUncoversSinceBoom = "uncovers-since-boom"
+	MetricDistance          = "distance"
+	MetricScore             = "score"
+	MetricBestStreak        = "best-streak"
+)
+
+// metric returns the current value of the named metric for p. It returns false if there is no such metric.
+func (p *Player) metric(name string) (uint64, bool) {
+	switch name {
+	case MetricScore:
+		return uint64(p.getScore()), true
+	case MetricBestStreak:
+		return uint64(p.getBestStreak()), true
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	switch name {
+	case MetricCellsUncovered:
+		return p.Stats.CellsUncovered, true
+	case MetricFlagsCorrect:
+		return p.Stats.FlagsCorrect, true
+	case MetricUncoversSinceBoom:
+		return p.Stats.UncoversSinceBoom, true
+	case MetricDistance:
+		return p.Stats.MaxDistance, true
+	default:
+		return 0, false
+	}
+}
+
+// isMetric returns true if name is a known metric
+func isMetric(name string) bool {
+	switch name {
+	case MetricCellsUncovered, MetricFlagsCorrect, MetricUncoversSinceBoom, MetricDistance, MetricScore, MetricBestStreak:
+		return true
+	}
+	return false
+}
+
+// visit records that the player reached pt. Must be called with p.mu held for writing.
+func (p *Player) visit(pt image.Point) {
+	dist := uint64(math.Hypot(float64(pt.X), float64(pt.Y)))
+	if dist > p.Stats.MaxDistance {
+		p.Stats.MaxDistance = dist
+	}
+}
+
+// ProvenMine returns true if the visible fields around pt prove that pt contains a mine. Flags are not trusted.
+func (m *MineField) ProvenMine(pt image.Point) bool {
+	rect := image.Rect(pt.X-_flagProofRadius, pt.Y-_flagProofRadius, pt.X+_flagProofRadius+1, pt.Y+_flagProofRadius+1)
+	board := m.VisibleBoard(rect)
+	board.TrustFlags = false
+
+	for _, mine := range solver.Solve(board).Mines {
+		if mine == pt {
+			return true
+		}
+	}
+	return false
+}
+
+// statsEvent updates the statistics of the player who caused ev
+func (s *Server) statsEvent(ev GameEvent) {
+	if p := s.GetPlayer(eventPlayerID(ev)); p != nil {
+		p.mu.Lock()
+		p.Stats.LastSeen = time.Now()
+		p.mu.Unlock()
+	}
+
+	switch ev := ev.(type) {
+	case CellsUncovered:
+		p := s.GetPlayer(ev.PlayerID)
+		if p == nil {
+			return
+		}
+		p.mu.Lock()
+		p.Stats.CellsUncovered += uint64(ev.Revealed)
+		p.Stats.UncoversSinceBoom++
+		p.visit(ev.At)
+		p.mu.Unlock()
+		s.resolveFlags(s.flagArea(ev.At))
+	case MineTriggered:
+		p := s.GetPlayer(ev.PlayerID)
+		if p == nil {
+			return
+		}
+		p.mu.Lock()
+		p.Stats.Booms++
+		p.Stats.UncoversSinceBoom = 0
+		p.visit(ev.At)
+		p.mu.Unlock()
+		s.resolveFlags(s.flagArea(ev.At))
+	case MarkChanged:
+		if ev.Mark != MarkFlag {
+			return
+		}
+		// Flags on fields that are already revealed don't tell anything
+		if _, revealed := s.m.revealed(ev.At); revealed {
+			return
+		}
+		p := s.GetPlayer(ev.PlayerID)
+		if p == nil {
+			return
+		}
+		p.mu.Lock()
+		if p.Stats.FlaggedCells == nil {
+			p.Stats.FlaggedCells = make(map[image.Point]bool)
+		}
+		first := !p.Stats.FlaggedCells[ev.At]
+		if first {
+			p.Stats.FlaggedCells[ev.At] = true
+			p.Stats.FlagsPlaced++
+		}
+		p.mu.Unlock()
+
+		if first {
+			s.addPendingFlag(ev.At, p.Id)
+			s.resolveFlags(image.Rect(ev.At.X, ev.At.Y, ev.At.X+1, ev.At.Y+1))
+		}
+	case PlayerMoved:
+		p := s.GetPlayer(ev.PlayerID)
+		if p == nil {
+			return
+		}
+		p.mu.Lock()
+		p.Stats.DistanceTravelled += uint64(math.Hypot(float64(ev.Delta.X), float64(ev.Delta.Y)))
+		p.visit(ev.Viewport.Min.Add(ev.Viewport.Size().Div(2)))
+		p.mu.Unlock()
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/farhaven/sweeper/protocol"
+)
+
+// addToStreak extends the player's streak of safe uncovers by one and returns the new streak length. The best streak is updated
+// if necessary.
+func (p *Player) addToStreak() uint {
+	streak := atomic.AddUint64(&p.Streak, 1)
+	p.raiseBestStreak(uint(streak))
+
+	p.s.leaderboard(BoardStreak).Raise(p.Id, uint(streak))
+	p.s.checkStreakRecord(p, uint(streak))
+
+	return uint(streak)
+}
+
+// raiseBestStreak sets the best streak of the player to streak if it is longer than the current one
+func (p *Player) raiseBestStreak(streak uint) {
+	for {
+		best := atomic.LoadUint64(&p.BestStreak)
+		if uint64(streak) <= best || atomic.CompareAndSwapUint64(&p.BestStreak, best, uint64(streak)) {
+			break
+		}
+	}
+}
+
+func (p *Player) resetStreak() {
+	atomic.StoreUint64(&p.Streak, 0)
+
+	p.mu.Lock()
+	p.recordAnnounced = false
+	p.mu.Unlock()
+}
+
+func (p *Player) getStreak() uint {
+	return uint(atomic.LoadUint64(&p.Streak))
+}
+
+func (p *Player) getBestStreak() uint {
+	return uint(atomic.LoadUint64(&p.BestStreak))
+}
+
+// awardPoints adds points to the player's score after applying the streak multiplier of the rules. It returns the number of
+// points that were actually added.
+func (p *Player) awardPoints(points uint) uint {
+	streak := p.addToStreak()
+	awarded := uint(float64(points) * p.s.m.Rules().StreakMultiplier(streak))
+	p.incScore(awarded)
+	p.notifyScore(int(awarded), protocol.ReasonUncover)
+	return awarded
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/farhaven/sweeper/protocol"
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+)
+
+const _telnetHelp = `Commands:
+  move DX DY      move your viewport
+  uncover X Y     uncover the field at X, Y relative to your viewport
+  mark X Y        cycle the mark on the field at X, Y relative to your viewport
+  name NAME       set your name
+  hint            get a hint for your viewport, costs points
+  profile [NAME]  show the statistics of a player, yourself if NAME is empty
+  report NAME     report the name of a player to the admins
+  look            show your viewport
+  help            show this help
+  quit            disconnect
+`
+
+// ServeTelnet listens on the TCP address addr and serves a line based text protocol to players that don't use a browser.
+func (s *Server) ServeTelnet(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	log.Println("listening for telnet players on", addr)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleTelnet(conn)
+	}
+}
+
+// parseTelnetCommand translates a command line into a client request. Commands that are handled locally by the telnet session
+// are returned with their name as kind.
+func parseTelnetCommand(line string) (protocol.ClientRequest, error) {
+	var req protocol.ClientRequest
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return req, fmt.Errorf("empty command")
+	}
+
+	switch fields[0] {
+	case "move", "uncover", "mark":
+		if len(fields) != 3 {
+			return req, fmt.Errorf("%s needs two arguments", fields[0])
+		}
+		x, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return req, err
+		}
+		y, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return req, err
+		}
+		req.Kind, req.X, req.Y = fields[0], x, y
+	case "name":
+		req.Kind = protocol.KindUpdateName
+		req.Name = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "name"))
+	case "hint":
+		req.Kind = protocol.KindHint
+	case "profile":
+		req.Kind = protocol.KindProfile
+		req.Name = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "profile"))
+	case "report":
+		req.Kind = protocol.KindReport
+		req.Name = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "report"))
+		if req.Name == "" {
+			return req, fmt.Errorf("report needs the name of a player")
+		}
+	case "look", "help", "quit":
+		req.Kind = fields[0]
+	default:
+		return req, fmt.Errorf("unknown command %q", fields[0])
+	}
+
+	return req, nil
+}
+
+func (s *Server) handleTelnet(conn net.Conn) {
+	defer conn.Close()
+
+	in := bufio.NewScanner(conn)
+
+	fmt.Fprintf(conn, "Welcome to sweeper!\nSession token (empty for a new player): ")
+	if !in.Scan() {
+		return
+	}
+
+	var playerID string
+	if token := strings.TrimSpace(in.Text()); token != "" {
+		id, issued, err := s.verifySession(token)
+		if err == nil && isBotID(id) {
+			err = errInvalidToken
+		}
+		if err != nil {
+			log.Println("denying telnet session:", err)
+			fmt.Fprintln(conn, "Denied:", err)
+			return
+		}
+		playerID = id
+		if time.Since(issued) > _sessionRotation {
+			fmt.Fprintf(conn, "Your session token was renewed, use this one from now on: %s\n", s.sessions.Issue(playerID))
+		}
+	} else {
+		playerID = uuid.New().String()
+		fmt.Fprintf(conn, "Your session token is %s\nKeep it secret, anyone who knows it can play as you.\n", s.sessions.Issue(playerID))
+	}
+
+	p := s.AddPlayer(playerID)
+	p.setHasSession()
+	log.Println("running telnet session for player", p)
+	since := p.connected()
+	defer p.disconnected(since)
+
+	look := func() {
+		p.mu.RLock()
+		vp := s.m.ExtractPlayerView(p.Viewport)
+		lives := p.Lives
+		p.mu.RUnlock()
+		fmt.Fprintf(conn, "Score: %d, lives: %d\n%s", p.getScore(), lives, vp)
+	}
+
+	// Print events as they happen
+	events := make(chan protocol.Event, _eventBuffer)
+	s.AddEventChannel(events, p)
+	go func() {
+		for ev := range events {
+			_, err := fmt.Fprintf(conn, "*** %s\n", formatEvent(ev))
+			if err != nil {
+				return
+			}
+		}
+	}()
+	defer func() {
+		// Unregister before closing so that no one sends on the closed channel
+		s.RemoveEventChannel(events)
+		close(events)
+	}()
+
+	fmt.Fprint(conn, _telnetHelp)
+	look()
+
+	// Commands are throttled like the requests of players on websockets
+	requestLimit := rate.NewLimiter(_playerRequestRate, _playerRequestBurst)
+
+	for {
+		fmt.Fprint(conn, "> ")
+		if !in.Scan() {
+			break
+		}
+
+		err := requestLimit.Wait(context.Background())
+		if err != nil {
+			log.Println("can't wait for request rate limit:", err)
+			return
+		}
+
+		req, err := parseTelnetCommand(in.Text())
+		if err != nil {
+			fmt.Fprintln(conn, "error:", err)
+			continue
+		}
+
+		switch req.Kind {
+		case "quit":
+			return
+		case "help":
+			fmt.Fprint(conn, _telnetHelp)
+			continue
+		case "look":
+		default:
+			err = p.HandleRequest(req, nil)
+			if err != nil {
+				fmt.Fprintln(conn, "error:", err)
+				continue
+			}
+		}
+
+		look()
+	}
+
+	log.Println("telnet player", p, "disconnected")
+}
+
+// formatEvent returns a human readable description of ev
+func formatEvent(ev protocol.Event) string {
+	switch ev.Event {
+	case protocol.EventBoom:
+		b := ev.Boom
+		if !b.Own {
+			return fmt.Sprintf("BOOM! %s triggered a mine at %d %d", b.Player, b.X, b.Y)
+		}
+
+		var msg strings.Builder
+		fmt.Fprintf(&msg, "BOOM at %d %d!", b.X, b.Y)
+		if b.Forced {
+			msg.WriteString(" You had no safe choice, so the penalty is reduced.")
+		}
+		if b.RunEnded {
+			msg.WriteString(" That was your last life, your run is over.")
+		} else if b.LifeLost {
+			fmt.Fprintf(&msg, " You have %d lives left.", b.Lives)
+		}
+		fmt.Fprintf(&msg, " Wait %.0f seconds before playing on.", b.Cooldown)
+		return msg.String()
+	case protocol.EventScore:
+		return fmt.Sprintf("%+d points (%s), score is now %d", ev.Score.Delta, ev.Score.Reason, ev.Score.Score)
+	case protocol.EventCooldown:
+		return fmt.Sprintf("You're still cooling down for %.0f seconds", ev.Cooldown)
+	case protocol.EventHint:
+		if ev.Hint.Deduced {
+			return fmt.Sprintf("Hint: %s (%d %d)", ev.Hint.Message, ev.Hint.X, ev.Hint.Y)
+		}
+		return "Hint: " + ev.Hint.Message
+	case protocol.EventError:
+		return "Error: " + ev.Error
+	case protocol.EventFeed:
+		return "News: " + ev.Feed.Message
+	case protocol.EventProfile:
+		pr := ev.Profile
+		return fmt.Sprintf("Profile of %s: score %d, best streak %d, %d fields uncovered, %d booms, %d flags placed (%d correct), "+
+			"travelled %d, furthest %d from the origin, played %s, last seen %s, %d achievements", pr.Name, pr.Score, pr.BestStreak,
+			pr.CellsUncovered, pr.Booms, pr.FlagsPlaced, pr.FlagsCorrect, pr.DistanceTravelled, pr.MaxDistance,
+			time.Duration(pr.Playtime)*time.Second, pr.LastSeen.Format(time.RFC1123), pr.Achievements)
+	case protocol.EventAchievement:
+		return fmt.Sprintf("Achievement unlocked: %s (%s)", ev.Achievement.Name, ev.Achievement.Description)
+	case protocol.EventReported:
+		return fmt.Sprintf("Thanks, the admins will have a look at the name %s", ev.Reported)
+	default:
+		return ev.Event
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Deliveries of webhooks are attempted this many times, waiting twice as long after each failed attempt
+const _webhookAttempts = 5
+const _webhookBackoff = time.Second
+const _webhookTimeout = 10 * time.Second
+
+// Number of payloads waiting for delivery to a single webhook. Further events are dropped until the webhook catches up.
+const _webhookQueueSize = 100
+
+// Header that carries the signature of a webhook payload
+const _webhookSignatureHeader = "X-Sweeper-Signature"
+
+// Names of game events as used in webhook filters and payloads
+const (
+	HookCellsUncovered = "cells-uncovered"
+	HookMineTriggered  = "mine-triggered"
+	HookMarkChanged    = "mark-changed"
+	HookPlayerRenamed  = "player-renamed"
+	HookPlayerMoved    = "player-moved"
+	HookLeaderChanged  = "leader-changed"
+	HookAdminAction    = "admin-action"
+)
+
+// Events that happen on nearly every click. They are only sent to webhooks that ask for them by name.
+var _frequentHookEvents = map[string]bool{
+	HookCellsUncovered: true,
+	HookMarkChanged:    true,
+	HookPlayerMoved:    true,
+}
+
+// Webhook is an HTTP endpoint that receives game events. Payloads are signed with an HMAC-SHA256 of the body using Secret, sent
+// hex encoded in the X-Sweeper-Signature header as "sha256=<signature>". If Events is empty, all events except uncovers, marks and
+// moves are sent, otherwise only those named in it.
+type Webhook struct {
+	URL    string
+	Secret string
+	Events []string
+}
+
+type Webhooks struct {
+	Webhooks []Webhook
+}
+
+func NewWebhooksFromFile(path string) (Webhooks, error) {
+	var hooks Webhooks
+
+	fh, err := os.Open(path)
+	if err != nil {
+		log.Println("Can't get webhooks file:", err)
+		return hooks, err
+	}
+	defer fh.Close()
+
+	dec := json.NewDecoder(fh)
+	err = dec.Decode(&hooks)
+
+	return hooks, err
+}
+
+// WebhookPayload is the JSON body sent to webhooks. Player is the display name of the player involved in the event, if any.
+// Player IDs are never sent, since they allow to act on behalf of the player.
+type WebhookPayload struct {
+	Event    string
+	Time     time.Time
+	Player   string       `json:",omitempty"`
+	Location *image.Point `json:",omitempty"`
+	Message  string
+}
+
+// wants returns true if the webhook is interested in events with the given name
+func (h Webhook) wants(event string) bool {
+	if len(h.Events) == 0 {
+		return !_frequentHookEvents[event]
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// sign returns the value of the signature header for body
+func (h Webhook) sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(h.Secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookSender delivers payloads to a webhook one after another, in the order of the events
+type webhookSender struct {
+	hook    Webhook
+	client  *http.Client
+	backoff time.Duration
+	queue   chan []byte
+}
+
+// newWebhookSender starts delivering payloads to h. Failed deliveries are retried after backoff, doubling it after each attempt.
+func newWebhookSender(h Webhook, client *http.Client, backoff time.Duration) *webhookSender {
+	w := &webhookSender{
+		hook:    h,
+		client:  client,
+		backoff: backoff,
+		queue:   make(chan []byte, _webhookQueueSize),
+	}
+	go w.run()
+	return w
+}
+
+// send queues body for delivery. It is dropped if the queue is full.
+func (w *webhookSender) send(body []byte) {
+	select {
+	case w.queue <- body:
+	default:
+		log.Println("webhook queue for", w.hook.URL, "is full, dropping event")
+	}
+}
+
+func (w *webhookSender) run() {
+	for body := range w.queue {
+		w.deliver(body)
+	}
+}
+
+// deliver sends body to the webhook, retrying with exponential backoff until it is accepted with a 2xx status or all attempts
+// failed.
+func (w *webhookSender) deliver(body []byte) {
+	backoff := w.backoff
+
+	for attempt := 1; attempt <= _webhookAttempts; attempt++ {
+		err := w.hook.post(w.client, body)
+		if err == nil {
+			return
+		}
+		log.Printf("webhook delivery to %s failed (attempt %d/%d): %s", w.hook.URL, attempt, _webhookAttempts, err)
+
+		if attempt < _webhookAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	log.Println("giving up on webhook delivery to", w.hook.URL)
+}
+
+func (h Webhook) post(client *http.Client, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(_webhookSignatureHeader, h.sign(body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// subscribeWebhooks sends game events to the given webhooks. Deliveries happen in the background so that slow endpoints don't
+// hold up the game, each webhook has its own queue.
+func (s *Server) subscribeWebhooks(hooks Webhooks) {
+	if len(hooks.Webhooks) == 0 {
+		return
+	}
+
+	client := &http.Client{Timeout: _webhookTimeout}
+	var senders []*webhookSender
+	for _, h := range hooks.Webhooks {
+		senders = append(senders, newWebhookSender(h, client, _webhookBackoff))
+	}
+
+	s.bus.Subscribe(func(ev GameEvent) {
+		payload, ok := s.webhookPayload(ev)
+		if !ok {
+			return
+		}
+
+		body, err := json.Marshal(payload)
+		if err != nil {
+			log.Println("can't encode webhook payload:", err)
+			return
+		}
+
+		for _, w := range senders {
+			if w.hook.wants(payload.Event) {
+				w.send(body)
+			}
+		}
+	})
+}
+
+// webhookPayload describes ev for webhooks. It returns false for events that aren't sent to webhooks.
+func (s *Server) webhookPayload(ev GameEvent) (WebhookPayload, bool) {
+	payload := WebhookPayload{
+		Time: time.Now(),
+	}
+
+	nameOf := func(id string) string {
+		p := s.GetPlayer(id)
+		if p == nil {
+			return _anonName
+		}
+		return p.displayName()
+	}
+
+	switch ev := ev.(type) {
+	case CellsUncovered:
+		payload.Event = HookCellsUncovered
+		payload.Player = nameOf(ev.PlayerID)
+		payload.Location = &ev.At
+		payload.Message = fmt.Sprintf("%s uncovered %d fields", payload.Player, ev.Revealed)
+	case MineTriggered:
+		payload.Event = HookMineTriggered
+		payload.Player = nameOf(ev.PlayerID)
+		payload.Location = &ev.At
+		payload.Message = fmt.Sprintf("%s triggered a mine at %d, %d", payload.Player, ev.At.X, ev.At.Y)
+	case MarkChanged:
+		payload.Event = HookMarkChanged
+		payload.Player = nameOf(ev.PlayerID)
+		payload.Location = &ev.At
+		payload.Message = fmt.Sprintf("%s changed the mark at %d, %d", payload.Player, ev.At.X, ev.At.Y)
+	case PlayerRenamed:
+		payload.Event = HookPlayerRenamed
+		payload.Player = ev.NewName
+		payload.Message = fmt.Sprintf("%s is now known as %s", ev.OldName, ev.NewName)
+	case PlayerMoved:
+		payload.Event = HookPlayerMoved
+		payload.Player = nameOf(ev.PlayerID)
+		center := ev.Viewport.Min.Add(ev.Viewport.Size().Div(2))
+		payload.Location = &center
+		payload.Message = fmt.Sprintf("%s moved to %d, %d", payload.Player, center.X, center.Y)
+	case LeaderChanged:
+		payload.Event = HookLeaderChanged
+		payload.Player = ev.Name
+		payload.Message = fmt.Sprintf("%s takes the lead with %d points", ev.Name, ev.Score)
+	case AdminAction:
+		payload.Event = HookAdminAction
+		payload.Player = nameOf(ev.PlayerID)
+		payload.Message = fmt.Sprintf("admin %s sent request %s", payload.Player, ev.Request)
+	default:
+		return payload, false
+	}
+
+	return payload, true
+}
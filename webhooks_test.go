@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookRecorder is a webhook endpoint that fails the first failures requests with a 500 and records the ones it accepts
+type webhookRecorder struct {
+	mu       sync.Mutex
+	failures int
+	attempts int
+	bodies   [][]byte
+	headers  []http.Header
+	received chan struct{}
+}
+
+func newWebhookRecorder(failures int) *webhookRecorder {
+	return &webhookRecorder{
+		failures: failures,
+		received: make(chan struct{}, 10),
+	}
+}
+
+func (rec *webhookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rec.mu.Lock()
+	rec.attempts++
+	if rec.attempts <= rec.failures {
+		rec.mu.Unlock()
+		http.Error(w, "try again later", http.StatusInternalServerError)
+		return
+	}
+	rec.bodies = append(rec.bodies, body)
+	rec.headers = append(rec.headers, r.Header.Clone())
+	rec.mu.Unlock()
+
+	rec.received <- struct{}{}
+}
+
+func (rec *webhookRecorder) wait(t *testing.T) {
+	t.Helper()
+
+	select {
+	case <-rec.received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook wasn't delivered")
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	rec := newWebhookRecorder(0)
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	h := Webhook{URL: srv.URL, Secret: "s3cret"}
+	w := newWebhookSender(h, srv.Client(), time.Millisecond)
+	body := []byte(`{"Event":"mine-triggered"}`)
+	w.send(body)
+	rec.wait(t)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if string(rec.bodies[0]) != string(body) {
+		t.Errorf("got body %q, want %q", rec.bodies[0], body)
+	}
+	if ct := rec.headers[0].Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got content type %q, want application/json", ct)
+	}
+
+	mac := hmac.New(sha256.New, []byte(h.Secret))
+	mac.Write(body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := rec.headers[0].Get(_webhookSignatureHeader); got != want {
+		t.Errorf("got signature %q, want %q", got, want)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	rec := newWebhookRecorder(2)
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	w := newWebhookSender(Webhook{URL: srv.URL}, srv.Client(), time.Millisecond)
+	w.send([]byte(`{"Event":"leader-changed"}`))
+	rec.wait(t)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if rec.attempts != 3 {
+		t.Errorf("got %d attempts, want 3", rec.attempts)
+	}
+	if len(rec.bodies) != 1 {
+		t.Errorf("got %d deliveries, want 1", len(rec.bodies))
+	}
+}
+
+func TestWebhookWants(t *testing.T) {
+	all := Webhook{}
+	some := Webhook{Events: []string{HookPlayerMoved, HookLeaderChanged}}
+
+	for _, tc := range []struct {
+		hook  Webhook
+		event string
+		want  bool
+	}{
+		{all, HookMineTriggered, true},
+		{all, HookLeaderChanged, true},
+		{all, HookAdminAction, true},
+		{all, HookCellsUncovered, false},
+		{all, HookMarkChanged, false},
+		{all, HookPlayerMoved, false},
+		{some, HookPlayerMoved, true},
+		{some, HookLeaderChanged, true},
+		{some, HookMineTriggered, false},
+		{some, HookCellsUncovered, false},
+	} {
+		if got := tc.hook.wants(tc.event); got != tc.want {
+			t.Errorf("webhook with events %v wants %s: got %v, want %v", tc.hook.Events, tc.event, got, tc.want)
+		}
+	}
+}
+
+func TestWebhookFilter(t *testing.T) {
+	leader := newWebhookRecorder(0)
+	leaderSrv := httptest.NewServer(leader)
+	defer leaderSrv.Close()
+
+	mines := newWebhookRecorder(0)
+	minesSrv := httptest.NewServer(mines)
+	defer minesSrv.Close()
+
+	s := &Server{bus: NewBus()}
+	s.subscribeWebhooks(Webhooks{Webhooks: []Webhook{
+		{URL: leaderSrv.URL, Events: []string{HookLeaderChanged}},
+		{URL: minesSrv.URL, Events: []string{HookMineTriggered}},
+	}})
+
+	s.bus.Publish(LeaderChanged{Name: "alice", Score: 42})
+	leader.wait(t)
+
+	select {
+	case <-mines.received:
+		t.Error("webhook received an event it didn't ask for")
+	case <-time.After(100 * time.Millisecond):
+	}
+}